@@ -0,0 +1,361 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package exfat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"time"
+	"unicode"
+	"unicode/utf16"
+)
+
+type bootSector struct {
+	JumpBoot                    [3]byte
+	FileSystemName              [8]byte
+	_                           [53]byte // MustBeZero
+	PartitionOffset             uint64
+	VolumeLength                uint64
+	FatOffset                   uint32
+	FatLength                   uint32
+	ClusterHeapOffset           uint32
+	ClusterCount                uint32
+	FirstClusterOfRootDirectory uint32
+	VolumeSerialNumber          uint32
+	FileSystemRevision          uint16
+	VolumeFlags                 uint16
+	BytesPerSectorShift         uint8
+	SectorsPerClusterShift      uint8
+	NumberOfFats                uint8
+	DriveSelect                 uint8
+	PercentInUse                uint8
+	_                           [7]byte // Reserved
+	BootCode                    [390]byte
+	BootSignature               uint16
+}
+
+func (b *bootSector) valid() error {
+	if string(b.FileSystemName[:]) != "EXFAT   " || b.BootSignature != 0xAA55 {
+		return errors.New("not an exFAT volume")
+	}
+	if b.BytesPerSectorShift < 9 || b.BytesPerSectorShift > 12 ||
+		b.SectorsPerClusterShift > 25-b.BytesPerSectorShift {
+		return errors.New("invalid exFAT boot sector")
+	}
+	if b.NumberOfFats != 1 && b.NumberOfFats != 2 {
+		return errors.New("invalid exFAT boot sector")
+	}
+	return nil
+}
+
+const (
+	entryTypeEndOfDirectory = 0x00
+	entryTypeBitmap         = 0x81
+	entryTypeUpcase         = 0x82
+	entryTypeLabel          = 0x83
+	entryTypeFile           = 0x85
+	entryTypeStream         = 0xC0
+	entryTypeName           = 0xC1
+
+	entryTypeInUse = 0x80
+)
+
+type fileEntry struct {
+	EntryType                 uint8
+	SecondaryCount            uint8
+	SetChecksum               uint16
+	FileAttributes            uint16
+	_                         uint16 // Reserved
+	CreateTimestamp           uint32
+	LastModifiedTimestamp     uint32
+	LastAccessedTimestamp     uint32
+	Create10msIncrement       uint8
+	LastModified10msIncrement uint8
+	CreateUtcOffset           uint8
+	LastModifiedUtcOffset     uint8
+	LastAccessedUtcOffset     uint8
+	_                         [7]byte // Reserved
+}
+
+type streamExtensionEntry struct {
+	EntryType             uint8
+	GeneralSecondaryFlags uint8
+	_                     uint8 // Reserved
+	NameLength            uint8
+	NameHash              uint16
+	_                     uint16 // Reserved
+	ValidDataLength       uint64
+	_                     uint32 // Reserved
+	FirstCluster          uint32
+	DataLength            uint64
+}
+
+type fileNameEntry struct {
+	EntryType             uint8
+	GeneralSecondaryFlags uint8
+	FileName              [15]uint16
+}
+
+// allocationEntry describes the allocation bitmap and the up-case table
+// entries, which both point to a cluster chain.
+type allocationEntry struct {
+	EntryType     uint8
+	BitmapFlags   uint8
+	_             [2]byte // Reserved
+	TableChecksum uint32
+	_             [12]byte // Reserved
+	FirstCluster  uint32
+	DataLength    uint64
+}
+
+type volumeLabelEntry struct {
+	EntryType      uint8
+	CharacterCount uint8
+	VolumeLabel    [11]uint16
+	_              [8]byte // Reserved
+}
+
+// entrySet is a parsed file directory entry set consisting of a file entry,
+// a stream extension entry and one or more file name entries.
+type entrySet struct {
+	name          string
+	file          fileEntry
+	stream        streamExtensionEntry
+	checksumValid bool
+}
+
+// parseDirectory parses all in-use entries of a directory. Entries of the
+// root directory that are not entry sets are returned separately.
+func parseDirectory(data []byte) (sets []*entrySet, other [][]byte) {
+	for i := 0; i+32 <= len(data); i += 32 {
+		entryType := data[i]
+		if entryType == entryTypeEndOfDirectory {
+			break
+		}
+		if entryType&entryTypeInUse == 0 {
+			continue
+		}
+		if entryType != entryTypeFile {
+			if entryType&0x40 == 0 { // primary entries only
+				other = append(other, data[i:i+32])
+			}
+			continue
+		}
+
+		secondaryCount := int(data[i+1])
+		end := i + 32*(secondaryCount+1)
+		if secondaryCount < 2 || end > len(data) {
+			continue
+		}
+		set, err := parseEntrySet(data[i:end])
+		if err != nil {
+			continue
+		}
+		sets = append(sets, set)
+		i = end - 32
+	}
+	return sets, other
+}
+
+func parseEntrySet(data []byte) (*entrySet, error) {
+	set := &entrySet{}
+	if err := binary.Read(bytes.NewReader(data[0:32]), binary.LittleEndian, &set.file); err != nil {
+		return nil, err
+	}
+	if data[32] != entryTypeStream {
+		return nil, errors.New("missing stream extension entry")
+	}
+	if err := binary.Read(bytes.NewReader(data[32:64]), binary.LittleEndian, &set.stream); err != nil {
+		return nil, err
+	}
+
+	var name []uint16
+	for offset := 64; offset < len(data) && len(name) < int(set.stream.NameLength); offset += 32 {
+		if data[offset] != entryTypeName {
+			break
+		}
+		nameEntry := fileNameEntry{}
+		if err := binary.Read(bytes.NewReader(data[offset:offset+32]), binary.LittleEndian, &nameEntry); err != nil {
+			return nil, err
+		}
+		name = append(name, nameEntry.FileName[:]...)
+	}
+	if len(name) < int(set.stream.NameLength) {
+		return nil, errors.New("incomplete file name")
+	}
+
+	set.name = string(utf16.Decode(name[:set.stream.NameLength]))
+	set.checksumValid = entrySetChecksum(data) == set.file.SetChecksum
+	return set, nil
+}
+
+// entrySetChecksum calculates the checksum of a directory entry set, skipping
+// the SetChecksum field itself.
+func entrySetChecksum(data []byte) uint16 {
+	var checksum uint16
+	for i, b := range data {
+		if i == 2 || i == 3 {
+			continue
+		}
+		checksum = (checksum<<15 | checksum>>1) + uint16(b)
+	}
+	return checksum
+}
+
+// tableChecksum calculates the checksum of the up-case table.
+func tableChecksum(data []byte) uint32 {
+	var checksum uint32
+	for _, b := range data {
+		checksum = (checksum<<31 | checksum>>1) + uint32(b)
+	}
+	return checksum
+}
+
+// decodeUpcase expands the compressed up-case table. A 0xFFFF entry is
+// followed by the number of characters that map to themselves.
+func decodeUpcase(data []byte) []uint16 {
+	var table []uint16
+	for i := 0; i+1 < len(data) && len(table) < 0x10000; i += 2 {
+		c := binary.LittleEndian.Uint16(data[i:])
+		if c == 0xFFFF && i+3 < len(data) {
+			count := int(binary.LittleEndian.Uint16(data[i+2:]))
+			for j := 0; j < count; j++ {
+				table = append(table, uint16(len(table)))
+			}
+			i += 2
+			continue
+		}
+		table = append(table, c)
+	}
+	return table
+}
+
+// upcaseName converts a name to its up-cased UTF-16 representation used for
+// comparing file names.
+func upcaseName(table []uint16, name string) []uint16 {
+	chars := utf16.Encode([]rune(name))
+	for i, c := range chars {
+		switch {
+		case int(c) < len(table):
+			chars[i] = table[c]
+		case len(table) == 0 && (c < 0xD800 || c > 0xDFFF):
+			chars[i] = uint16(unicode.ToUpper(rune(c)))
+		}
+	}
+	return chars
+}
+
+// timestamp converts an exFAT timestamp, its 10 ms increment and UTC offset
+// into a time.Time. Timestamps without a valid UTC offset are returned in UTC.
+func timestamp(ts uint32, increment10ms, utcOffset uint8) time.Time {
+	if ts == 0 {
+		return time.Time{}
+	}
+
+	loc := time.UTC
+	if utcOffset&0x80 != 0 {
+		// 7 bit signed offset in 15 minute intervals
+		offset := int(int8(utcOffset<<1)>>1) * 15 * 60
+		loc = time.FixedZone("", offset)
+	}
+
+	return time.Date(
+		int(ts>>25)+1980, time.Month(ts>>21&0x0F), int(ts>>16&0x1F),
+		int(ts>>11&0x1F), int(ts>>5&0x3F), int(ts&0x1F)*2,
+		int(increment10ms)*10*int(time.Millisecond), loc,
+	)
+}
+
+// extent describes a run of consecutive clusters on the volume.
+type extent struct {
+	start  int64 // offset in the stream
+	offset int64 // offset on the volume
+	length int64
+}
+
+// streamReader provides a contiguous view on the clusters of a stream. Bytes
+// beyond the valid data length are returned as zeros.
+type streamReader struct {
+	r       io.ReaderAt
+	extents []extent
+	size    int64
+	valid   int64
+}
+
+func newStreamReader(r io.ReaderAt, extents []extent, valid int64) *streamReader {
+	var size int64
+	for i := range extents {
+		extents[i].start = size
+		size += extents[i].length
+	}
+	if valid > size || valid < 0 {
+		valid = size
+	}
+	return &streamReader{r: r, extents: extents, size: size, valid: valid}
+}
+
+// ReadAt reads len(p) bytes starting at offset off of the stream.
+func (s *streamReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= s.size {
+		return 0, io.EOF
+	}
+
+	idx := sort.Search(len(s.extents), func(i int) bool {
+		return s.extents[i].start+s.extents[i].length > off
+	})
+
+	for ; idx < len(s.extents) && n < len(p); idx++ {
+		e := s.extents[idx]
+		rel := off + int64(n) - e.start
+		toRead := e.length - rel
+		if toRead > int64(len(p)-n) {
+			toRead = int64(len(p) - n)
+		}
+
+		m, err := s.r.ReadAt(p[n:n+int(toRead)], e.offset+rel)
+		n += m
+		if err != nil && !(err == io.EOF && int64(m) == toRead) {
+			return n, err
+		}
+	}
+
+	// zero bytes beyond the valid data length
+	start := s.valid - off
+	if start < 0 {
+		start = 0
+	}
+	for i := start; i < int64(n); i++ {
+		p[i] = 0
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
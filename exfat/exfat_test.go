@@ -0,0 +1,304 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package exfat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const longName = "Long file name with more than fifteen characters.txt"
+
+func TestFS(t *testing.T) {
+	img := newTestImage()
+	modified := time.Date(2021, time.March, 4, 5, 6, 8, 0, time.FixedZone("", 2*60*60))
+	created := time.Date(2020, time.December, 24, 18, 0, 1, 150*int(time.Millisecond), time.UTC)
+
+	readme := testContent(1300)
+	img.addFile(img.root, "README.md", 0x20, readme, []uint32{10, 11, 12}, true, -1, created, modified)
+
+	fragment := testContent(1400)
+	img.addFile(img.root, "Fragment.bin", 0x20, fragment, []uint32{20, 25, 21}, false, -1, created, modified)
+
+	deleted := img.addFile(img.root, "deleted.txt", 0x20, []byte("deleted"), []uint32{50}, true, -1, created, modified)
+	img.data[deleted] &^= entryTypeInUse
+
+	img.addFile(img.root, "dir", 0x10, make([]byte, 512), []uint32{30}, true, -1, created, modified)
+	img.addFile(30, longName, 0x20, []byte("long"), []uint32{31}, true, -1, created, modified)
+
+	sparse := bytes.Repeat([]byte{0xAA}, 1024)
+	img.addFile(img.root, "sparse.dat", 0x20, sparse, []uint32{40, 41}, true, 100, created, modified)
+
+	fsys, err := New(img.reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "Evidence", fsys.VolumeLabel())
+	assert.Equal(t, uint32(0x12345678), fsys.SerialNumber())
+	assert.True(t, fsys.ClusterAllocated(10))
+	assert.False(t, fsys.ClusterAllocated(500))
+
+	entries, err := fs.ReadDir(fsys, ".")
+	assert.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"Fragment.bin", "README.md", "dir", "sparse.dat"}, names)
+
+	got, err := fs.ReadFile(fsys, "README.md")
+	assert.NoError(t, err)
+	assert.Equal(t, readme, got)
+
+	got, err = fs.ReadFile(fsys, "Fragment.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, fragment, got)
+
+	got, err = fs.ReadFile(fsys, "DIR/"+longName)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("long"), got)
+
+	got, err = fs.ReadFile(fsys, "sparse.dat")
+	assert.NoError(t, err)
+	assert.Equal(t, append(bytes.Repeat([]byte{0xAA}, 100), make([]byte, 924)...), got)
+
+	info, err := fs.Stat(fsys, "readme.md")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "README.md", info.Name())
+		assert.True(t, modified.Equal(info.ModTime()))
+		_, offset := info.ModTime().Zone()
+		assert.Equal(t, 2*60*60, offset)
+
+		sys, ok := info.Sys().(*EntryInfo)
+		if assert.True(t, ok) {
+			assert.True(t, created.Equal(sys.Created), sys.Created)
+			assert.True(t, sys.NoFatChain)
+			assert.True(t, sys.ChecksumValid)
+			assert.True(t, sys.Archive)
+			assert.Equal(t, uint32(10), sys.FirstCluster)
+		}
+	}
+
+	_, err = fsys.Open("deleted.txt")
+	assert.Error(t, err)
+
+	assert.NoError(t, fstest.TestFS(fsys, "README.md", "Fragment.bin", "dir/"+longName, "sparse.dat"))
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(bytes.NewReader(make([]byte, 1024)))
+	assert.Error(t, err)
+}
+
+func testContent(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i * 7 / 3)
+	}
+	return content
+}
+
+// testImage builds small exFAT volumes in memory.
+type testImage struct {
+	boot bootSector
+	data []byte
+	fat  []uint32
+	root uint32
+}
+
+func newTestImage() *testImage {
+	img := &testImage{
+		boot: bootSector{
+			JumpBoot:                    [3]byte{0xEB, 0x76, 0x90},
+			VolumeLength:                1032,
+			FatOffset:                   24,
+			FatLength:                   8,
+			ClusterHeapOffset:           32,
+			ClusterCount:                1000,
+			FirstClusterOfRootDirectory: 4,
+			VolumeSerialNumber:          0x12345678,
+			FileSystemRevision:          0x0100,
+			BytesPerSectorShift:         9,
+			NumberOfFats:                1,
+			BootSignature:               0xAA55,
+		},
+		data: make([]byte, 1032*512),
+		fat:  make([]uint32, 1002),
+		root: 4,
+	}
+	copy(img.boot.FileSystemName[:], "EXFAT   ")
+	img.fat[0] = 0xFFFFFFF8
+	img.fat[1] = 0xFFFFFFFF
+
+	// root directory spans two fragmented clusters
+	img.fat[4] = 9
+	img.fat[9] = 0xFFFFFFFF
+
+	// up-case table mapping a-z to A-Z
+	var upcase []uint16
+	upcase = append(upcase, 0xFFFF, 'a')
+	for c := 'A'; c <= 'Z'; c++ {
+		upcase = append(upcase, uint16(c))
+	}
+	upcase = append(upcase, 0xFFFF, uint16(0x10000-'z'-1))
+	table := &bytes.Buffer{}
+	_ = binary.Write(table, binary.LittleEndian, upcase)
+	img.fat[3] = 0xFFFFFFFF
+	copy(img.data[img.clusterOffset(3):], table.Bytes())
+
+	img.fat[2] = 0xFFFFFFFF
+	img.writeEntry(img.root, &allocationEntry{EntryType: entryTypeBitmap, FirstCluster: 2, DataLength: 125})
+	img.writeEntry(img.root, &allocationEntry{EntryType: entryTypeUpcase, FirstCluster: 3, DataLength: uint64(table.Len()), TableChecksum: tableChecksum(table.Bytes())})
+
+	label := volumeLabelEntry{EntryType: entryTypeLabel, CharacterCount: 8}
+	copy(label.VolumeLabel[:], utf16.Encode([]rune("Evidence")))
+	img.writeEntry(img.root, &label)
+	return img
+}
+
+func (img *testImage) clusterOffset(cluster uint32) int64 {
+	return int64(img.boot.ClusterHeapOffset)*512 + int64(cluster-2)*512
+}
+
+// addFile adds a file entry set and its content. It returns the volume offset
+// of the file directory entry.
+func (img *testImage) addFile(dir uint32, name string, attr uint16, content []byte, clusters []uint32, noFatChain bool, valid int, created, modified time.Time) int64 {
+	for i, cluster := range clusters {
+		if !noFatChain {
+			img.fat[cluster] = 0xFFFFFFFF
+			if i > 0 {
+				img.fat[clusters[i-1]] = cluster
+			}
+		}
+		if i*512 < len(content) {
+			copy(img.data[img.clusterOffset(cluster):], content[i*512:])
+		}
+	}
+
+	chars := utf16.Encode([]rune(name))
+	nameEntries := (len(chars) + 14) / 15
+
+	_, createdOffset := created.Zone()
+	_, modifiedOffset := modified.Zone()
+	file := fileEntry{
+		EntryType:                 entryTypeFile,
+		SecondaryCount:            uint8(1 + nameEntries),
+		FileAttributes:            attr,
+		CreateTimestamp:           encodeTimestamp(created),
+		Create10msIncrement:       uint8(created.Second()%2*100 + created.Nanosecond()/int(10*time.Millisecond)),
+		CreateUtcOffset:           0x80 | uint8(createdOffset/(15*60))&0x7F,
+		LastModifiedTimestamp:     encodeTimestamp(modified),
+		LastModifiedUtcOffset:     0x80 | uint8(modifiedOffset/(15*60))&0x7F,
+		LastAccessedTimestamp:     encodeTimestamp(modified),
+		LastModified10msIncrement: uint8(modified.Second() % 2 * 100),
+	}
+	stream := streamExtensionEntry{
+		EntryType:       entryTypeStream,
+		NameLength:      uint8(len(chars)),
+		ValidDataLength: uint64(len(content)),
+		FirstCluster:    clusters[0],
+		DataLength:      uint64(len(content)),
+	}
+	if noFatChain {
+		stream.GeneralSecondaryFlags = 0x03
+	} else {
+		stream.GeneralSecondaryFlags = 0x01
+	}
+
+	if valid >= 0 {
+		stream.ValidDataLength = uint64(valid)
+	}
+
+	set := &bytes.Buffer{}
+	_ = binary.Write(set, binary.LittleEndian, &file)
+	_ = binary.Write(set, binary.LittleEndian, &stream)
+	for i := 0; i < nameEntries; i++ {
+		nameEntry := fileNameEntry{EntryType: entryTypeName}
+		copy(nameEntry.FileName[:], chars[i*15:])
+		_ = binary.Write(set, binary.LittleEndian, &nameEntry)
+	}
+	data := set.Bytes()
+	binary.LittleEndian.PutUint16(data[2:], entrySetChecksum(data))
+
+	offset := img.writeEntry(dir, data[:32])
+	for i := 32; i < len(data); i += 32 {
+		img.writeEntry(dir, data[i:i+32])
+	}
+	return offset
+}
+
+func (img *testImage) writeEntry(dir uint32, v interface{}) int64 {
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, binary.LittleEndian, v); err != nil {
+		panic(err)
+	}
+	for cluster := dir; cluster >= 2 && cluster < 0xFFFFFFF7; cluster = img.fat[cluster] {
+		for offset := img.clusterOffset(cluster); offset < img.clusterOffset(cluster)+512; offset += 32 {
+			if img.data[offset] == 0 {
+				copy(img.data[offset:], buf.Bytes())
+				return offset
+			}
+		}
+		if img.fat[cluster] == 0 {
+			break
+		}
+	}
+	panic("directory full")
+}
+
+func (img *testImage) reader() io.ReaderAt {
+	bitmap := make([]byte, 125)
+	allocated := []uint32{10, 11, 12, 30, 31, 40, 41}
+	for cluster := range img.fat {
+		if cluster >= 2 && img.fat[cluster] != 0 {
+			allocated = append(allocated, uint32(cluster))
+		}
+	}
+	for _, cluster := range allocated {
+		bitmap[(cluster-2)/8] |= 1 << ((cluster - 2) % 8)
+	}
+	copy(img.data[img.clusterOffset(2):], bitmap)
+
+	buf := &bytes.Buffer{}
+	_ = binary.Write(buf, binary.LittleEndian, &img.boot)
+	copy(img.data, buf.Bytes())
+
+	fat := &bytes.Buffer{}
+	_ = binary.Write(fat, binary.LittleEndian, img.fat)
+	copy(img.data[int64(img.boot.FatOffset)*512:], fat.Bytes())
+	return bytes.NewReader(img.data)
+}
+
+func encodeTimestamp(t time.Time) uint32 {
+	return uint32(t.Year()-1980)<<25 | uint32(t.Month())<<21 | uint32(t.Day())<<16 |
+		uint32(t.Hour())<<11 | uint32(t.Minute())<<5 | uint32(t.Second()/2)
+}
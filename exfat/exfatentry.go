@@ -0,0 +1,103 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package exfat
+
+import (
+	"io/fs"
+	"time"
+)
+
+// File attributes of exFAT file directory entries.
+const (
+	attrReadOnly  = 0x01
+	attrHidden    = 0x02
+	attrSystem    = 0x04
+	attrDirectory = 0x10
+	attrArchive   = 0x20
+)
+
+// EntryInfo contains the exFAT specific metadata of a file or directory. It is
+// returned by the Sys method of fs.FileInfo.
+type EntryInfo struct {
+	Attributes      uint16
+	ReadOnly        bool
+	Hidden          bool
+	System          bool
+	Archive         bool
+	Created         time.Time
+	Modified        time.Time
+	Accessed        time.Time
+	FirstCluster    uint32
+	NoFatChain      bool
+	ValidDataLength int64
+	DataLength      int64
+	ChecksumValid   bool
+}
+
+// Name returns the name of the file.
+func (e *entrySet) Name() string { return e.name }
+
+// IsDir returns if the entry is a directory.
+func (e *entrySet) IsDir() bool { return e.file.FileAttributes&attrDirectory != 0 }
+
+// Size returns the data length of the entry.
+func (e *entrySet) Size() int64 { return int64(e.stream.DataLength) }
+
+// Mode returns the fs.FileMode.
+func (e *entrySet) Mode() fs.FileMode {
+	if e.IsDir() {
+		return fs.ModeDir
+	}
+	return 0
+}
+
+// ModTime returns the last modification time.
+func (e *entrySet) ModTime() time.Time {
+	return timestamp(e.file.LastModifiedTimestamp, e.file.LastModified10msIncrement, e.file.LastModifiedUtcOffset)
+}
+
+// Type returns the type bits of the fs.FileMode.
+func (e *entrySet) Type() fs.FileMode { return e.Mode().Type() }
+
+// Info returns the fs.FileInfo for the entry.
+func (e *entrySet) Info() (fs.FileInfo, error) { return e, nil }
+
+// Sys returns the exFAT specific metadata as *EntryInfo.
+func (e *entrySet) Sys() interface{} {
+	attr := e.file.FileAttributes
+	return &EntryInfo{
+		Attributes:      attr,
+		ReadOnly:        attr&attrReadOnly != 0,
+		Hidden:          attr&attrHidden != 0,
+		System:          attr&attrSystem != 0,
+		Archive:         attr&attrArchive != 0,
+		Created:         timestamp(e.file.CreateTimestamp, e.file.Create10msIncrement, e.file.CreateUtcOffset),
+		Modified:        e.ModTime(),
+		Accessed:        timestamp(e.file.LastAccessedTimestamp, 0, e.file.LastAccessedUtcOffset),
+		FirstCluster:    e.stream.FirstCluster,
+		NoFatChain:      e.stream.GeneralSecondaryFlags&0x02 != 0,
+		ValidDataLength: int64(e.stream.ValidDataLength),
+		DataLength:      int64(e.stream.DataLength),
+		ChecksumValid:   e.checksumValid,
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+// Package exfat provides an io/fs implementation of the exFAT file system.
+//
+// File names are resolved case-insensitively using the up-case table of the
+// volume.
+package exfat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"unicode/utf16"
+)
+
+// FS implements a read-only file system for the exFAT file system.
+type FS struct {
+	decoder     io.ReaderAt
+	boot        bootSector
+	clusterSize int64
+	fat         []uint32
+	bitmap      []byte
+	upcase      []uint16
+	label       string
+}
+
+// New creates a new exfat FS.
+func New(decoder io.ReaderAt) (*FS, error) {
+	m := &FS{decoder: decoder}
+
+	err := binary.Read(io.NewSectionReader(decoder, 0, 512), binary.LittleEndian, &m.boot)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.boot.valid(); err != nil {
+		return nil, err
+	}
+
+	sectorSize := int64(1) << m.boot.BytesPerSectorShift
+	m.clusterSize = sectorSize << m.boot.SectorsPerClusterShift
+
+	// the second FAT is only used by TexFAT if it is marked active
+	fatOffset := int64(m.boot.FatOffset) * sectorSize
+	if m.boot.NumberOfFats == 2 && m.boot.VolumeFlags&0x01 != 0 {
+		fatOffset += int64(m.boot.FatLength) * sectorSize
+	}
+	fatData := make([]byte, int64(m.boot.FatLength)*sectorSize)
+	_, err = decoder.ReadAt(fatData, fatOffset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	for i := 0; i+4 <= len(fatData) && i/4 < int(m.boot.ClusterCount)+2; i += 4 {
+		m.fat = append(m.fat, binary.LittleEndian.Uint32(fatData[i:]))
+	}
+
+	err = m.readRootEntries()
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// readRootEntries reads the allocation bitmap, up-case table and volume label
+// from the root directory.
+func (m *FS) readRootEntries() error {
+	data, err := m.readAll(m.chainReader(m.boot.FirstClusterOfRootDirectory, 0, false, -1))
+	if err != nil {
+		return err
+	}
+
+	_, entries := parseDirectory(data)
+	for _, entry := range entries {
+		switch entry[0] {
+		case entryTypeBitmap:
+			bitmap := allocationEntry{}
+			if err := binary.Read(bytes.NewReader(entry), binary.LittleEndian, &bitmap); err != nil {
+				return err
+			}
+			// TexFAT volumes have a second bitmap for the second FAT
+			if uint16(bitmap.BitmapFlags&0x01) != m.boot.VolumeFlags&0x01 {
+				continue
+			}
+			size := int64(bitmap.DataLength)
+			m.bitmap, err = m.readAll(m.chainReader(bitmap.FirstCluster, size, false, size))
+			if err != nil {
+				return err
+			}
+		case entryTypeUpcase:
+			upcase := allocationEntry{}
+			if err := binary.Read(bytes.NewReader(entry), binary.LittleEndian, &upcase); err != nil {
+				return err
+			}
+			size := int64(upcase.DataLength)
+			table, err := m.readAll(m.chainReader(upcase.FirstCluster, size, false, size))
+			if err != nil {
+				return err
+			}
+			if tableChecksum(table) == upcase.TableChecksum {
+				m.upcase = decodeUpcase(table)
+			}
+		case entryTypeLabel:
+			label := volumeLabelEntry{}
+			if err := binary.Read(bytes.NewReader(entry), binary.LittleEndian, &label); err != nil {
+				return err
+			}
+			count := int(label.CharacterCount)
+			if count > len(label.VolumeLabel) {
+				count = len(label.VolumeLabel)
+			}
+			m.label = string(utf16.Decode(label.VolumeLabel[:count]))
+		}
+	}
+	return nil
+}
+
+// VolumeLabel returns the volume label stored in the root directory.
+func (m *FS) VolumeLabel() string { return m.label }
+
+// SerialNumber returns the volume serial number from the boot sector.
+func (m *FS) SerialNumber() uint32 { return m.boot.VolumeSerialNumber }
+
+// ClusterAllocated returns if a cluster is marked as allocated in the
+// allocation bitmap.
+func (m *FS) ClusterAllocated(cluster uint32) bool {
+	if cluster < 2 {
+		return false
+	}
+	idx := cluster - 2
+	if int(idx/8) >= len(m.bitmap) {
+		return false
+	}
+	return m.bitmap[idx/8]&(1<<(idx%8)) != 0
+}
+
+// Open opens a file for reading.
+func (m *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, fmt.Errorf("path %s invalid", name)
+	}
+
+	root := &entrySet{name: "."}
+	root.file.FileAttributes = attrDirectory
+	root.stream.FirstCluster = m.boot.FirstClusterOfRootDirectory
+	if name == "." {
+		return m.newItem(root), nil
+	}
+
+	current := root
+	for _, part := range strings.Split(name, "/") {
+		if !current.IsDir() {
+			return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+		}
+		sets, err := m.readDir(current)
+		if err != nil {
+			return nil, err
+		}
+		next, ok := m.lookup(sets, part)
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+		}
+		current = next
+	}
+	return m.newItem(current), nil
+}
+
+func (m *FS) lookup(sets []*entrySet, name string) (*entrySet, bool) {
+	upcased := upcaseName(m.upcase, name)
+	for _, set := range sets {
+		if set.name == name {
+			return set, true
+		}
+	}
+	for _, set := range sets {
+		if equalUTF16(upcaseName(m.upcase, set.name), upcased) {
+			return set, true
+		}
+	}
+	return nil, false
+}
+
+func equalUTF16(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// readDir parses the entry sets of a directory.
+func (m *FS) readDir(dir *entrySet) ([]*entrySet, error) {
+	data, err := m.readAll(m.stream(dir))
+	if err != nil {
+		return nil, err
+	}
+	sets, _ := parseDirectory(data)
+	return sets, nil
+}
+
+// stream returns a reader for the data of an entry set.
+func (m *FS) stream(set *entrySet) *streamReader {
+	if set.name == "." && set.stream.DataLength == 0 {
+		return m.chainReader(set.stream.FirstCluster, 0, false, -1)
+	}
+	size := int64(set.stream.DataLength)
+	noFatChain := set.stream.GeneralSecondaryFlags&0x02 != 0
+	return m.chainReader(set.stream.FirstCluster, size, noFatChain, int64(set.stream.ValidDataLength))
+}
+
+// chainReader returns a reader for the clusters starting at cluster. Clusters
+// of streams with the NoFatChain flag are contiguous, all others are looked up
+// in the FAT. If size is greater than zero only the clusters required to hold
+// size bytes are included.
+func (m *FS) chainReader(cluster uint32, size int64, noFatChain bool, valid int64) *streamReader {
+	var count int64
+	if size > 0 {
+		count = (size + m.clusterSize - 1) / m.clusterSize
+	}
+
+	var extents []extent
+	add := func(c uint32) {
+		offset := m.clusterOffset(c)
+		if len(extents) > 0 {
+			last := &extents[len(extents)-1]
+			if last.offset+last.length == offset {
+				last.length += m.clusterSize
+				return
+			}
+		}
+		extents = append(extents, extent{offset: offset, length: m.clusterSize})
+	}
+
+	if noFatChain {
+		for i := int64(0); i < count && m.validCluster(cluster+uint32(i)); i++ {
+			add(cluster + uint32(i))
+		}
+	} else {
+		seen := map[uint32]bool{}
+		for m.validCluster(cluster) && !seen[cluster] && (count == 0 || int64(len(seen)) < count) {
+			seen[cluster] = true
+			add(cluster)
+			if int(cluster) >= len(m.fat) {
+				break
+			}
+			cluster = m.fat[cluster]
+		}
+	}
+
+	r := newStreamReader(m.decoder, extents, valid)
+	if size > 0 && size < r.size {
+		r.size = size
+	}
+	return r
+}
+
+func (m *FS) validCluster(cluster uint32) bool {
+	return cluster >= 2 && cluster-2 < m.boot.ClusterCount
+}
+
+func (m *FS) clusterOffset(cluster uint32) int64 {
+	sectorSize := int64(1) << m.boot.BytesPerSectorShift
+	return int64(m.boot.ClusterHeapOffset)*sectorSize + int64(cluster-2)*m.clusterSize
+}
+
+func (m *FS) readAll(r *streamReader) ([]byte, error) {
+	data := make([]byte, r.size)
+	_, err := r.ReadAt(data, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
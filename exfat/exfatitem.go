@@ -0,0 +1,119 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package exfat
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"syscall"
+	"time"
+
+	"github.com/forensicanalysis/fslib"
+)
+
+// Item describes files and directories in the exFAT file system.
+type Item struct {
+	*io.SectionReader
+	fs        *FS
+	entry     *entrySet
+	dirOffset int
+}
+
+func (m *FS) newItem(entry *entrySet) *Item {
+	r := m.stream(entry)
+	return &Item{
+		fs:            m,
+		entry:         entry,
+		SectionReader: io.NewSectionReader(r, 0, r.size),
+	}
+}
+
+// Name returns the name of the file.
+func (i *Item) Name() string { return i.entry.Name() }
+
+// ReadDir returns up to n child items of a directory.
+func (i *Item) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !i.IsDir() {
+		return nil, errors.New("cannot call ReadDir on a file")
+	}
+
+	sets, err := i.fs.readDir(i.entry)
+	if err != nil {
+		return nil, err
+	}
+	var entries []fs.DirEntry
+	for _, set := range sets {
+		entries = append(entries, set)
+	}
+
+	entries, o, err := fslib.DirEntries(n, entries, i.dirOffset)
+	i.dirOffset += o
+	return entries, err
+}
+
+// Read reads bytes into the passed buffer.
+func (i *Item) Read(p []byte) (n int, err error) {
+	if i.IsDir() {
+		return 0, syscall.EPERM
+	}
+	return i.SectionReader.Read(p)
+}
+
+// ReadAt reads bytes starting at off into passed buffer.
+func (i *Item) ReadAt(p []byte, off int64) (n int, err error) {
+	if i.IsDir() {
+		return 0, syscall.EPERM
+	}
+	return i.SectionReader.ReadAt(p, off)
+}
+
+// Seek move the current offset to the given position.
+func (i *Item) Seek(offset int64, whence int) (int64, error) {
+	if i.IsDir() {
+		return 0, syscall.EPERM
+	}
+	return i.SectionReader.Seek(offset, whence)
+}
+
+// Close closes the file freeing the resource. Usually additional IO operations
+// fail after closing.
+func (*Item) Close() error { return nil }
+
+// Stat return an fs.FileInfo object that describes a file.
+func (i *Item) Stat() (fs.FileInfo, error) { return i, nil }
+
+// Mode returns the fs.FileMode.
+func (i *Item) Mode() fs.FileMode { return i.entry.Mode() }
+
+// ModTime returns the modification time.
+func (i *Item) ModTime() time.Time { return i.entry.ModTime() }
+
+// Sys returns the exFAT specific metadata as *EntryInfo.
+func (i *Item) Sys() interface{} { return i.entry.Sys() }
+
+// IsDir returns if the item is a file.
+func (i *Item) IsDir() bool { return i.entry.IsDir() }
+
+// Size returns the item's size.
+func (i *Item) Size() int64 { return i.entry.Size() }
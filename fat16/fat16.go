@@ -23,225 +23,396 @@
 package fat16
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
-	"os"
+	"io/fs"
 	"strings"
-	"unicode/utf16"
-	"unicode/utf8"
 )
 
-type volumeHeader struct {
-	JumpInstruction       [3]byte
-	CreatingSystemID      [8]byte
-	SectorSize            uint16
-	SectorsPerCluster     byte
-	ReservedSectorCount   uint16
-	FatCount              int8
-	RootdirEntryCount     uint16
-	SectorCountSmall      uint16
-	MediaID               byte
-	SectorsPerFat         uint16
-	SectorsPerTrack       uint16
-	SideCount             uint16
-	HiddenSectorCount     uint32
-	SectorCountLarge      uint32
+// biosParameterBlock contains the boot sector fields shared by all FAT
+// variants.
+type biosParameterBlock struct {
+	JumpInstruction     [3]byte
+	CreatingSystemID    [8]byte
+	SectorSize          uint16
+	SectorsPerCluster   byte
+	ReservedSectorCount uint16
+	FatCount            int8
+	RootdirEntryCount   uint16
+	SectorCountSmall    uint16
+	MediaID             byte
+	SectorsPerFat       uint16
+	SectorsPerTrack     uint16
+	SideCount           uint16
+	HiddenSectorCount   uint32
+	SectorCountLarge    uint32
+}
+
+// extendedBootRecord follows the BIOS parameter block on FAT12 and FAT16 and
+// the FAT32 specific fields on FAT32.
+type extendedBootRecord struct {
 	PhysicalDriveNumber   byte
 	CurrentHead           byte
 	ExtendedBootSignature byte
 	VolumeID              [4]byte
 	VolumeLabel           [11]byte
 	FsType                [8]byte
-	BootCode              [448]byte
-	BootSectorSignature   [2]byte
 }
 
-type lfnEntry struct {
-	SequenceNumber  uint8
-	Filename1       [10]byte
-	Attributes      byte
-	Type            byte
-	Checksum        byte
-	Filename2       [12]byte
-	Startingcluster uint16
-	Filename3       [4]byte
+// volumeHeader is the boot sector layout of FAT12 and FAT16.
+type volumeHeader struct {
+	biosParameterBlock
+	extendedBootRecord
+	BootCode            [448]byte
+	BootSectorSignature [2]byte
 }
 
-// func (m *FS) getDirectoryEntry(cluster int64, count uint16, name string) (filename string, de *namedEntry, err error) {
-func (m *FS) getDirectoryEntry(offset int64, count uint16, name string) (filename string, de *namedEntry, err error) {
-	if count == 0 {
-		count = m.vh.SectorSize * uint16(m.vh.SectorsPerCluster)
-	}
-	entries, err := m.getDirectoryEntries(offset, count)
-
-	pathParts := strings.SplitN(name, "/", 2)
-	currentName := pathParts[0]
-	if de, ok := entries[currentName]; ok {
-		if len(pathParts) > 1 {
-			offset := getOffset(int64(de.Startingcluster), m.vh)
-			return m.getDirectoryEntry(offset, count, pathParts[1])
-		}
-		return currentName, de, err
-	}
+// fat32VolumeHeader is the boot sector layout of FAT32.
+type fat32VolumeHeader struct {
+	biosParameterBlock
+	SectorsPerFat32  uint32
+	ExtFlags         uint16
+	FsVersion        uint16
+	RootCluster      uint32
+	FsInfoSector     uint16
+	BackupBootSector uint16
+	_                [12]byte // Reserved
+	extendedBootRecord
+	BootCode            [420]byte
+	BootSectorSignature [2]byte
+}
 
-	return currentName, nil, errors.New("file not found")
+// fsInfo is the FAT32 file system information sector.
+type fsInfo struct {
+	LeadSignature   uint32
+	_               [480]byte // Reserved
+	StructSignature uint32
+	FreeCount       uint32
+	NextFree        uint32
+	_               [12]byte // Reserved
+	TrailSignature  uint32
 }
 
-func (m *FS) getDirectoryEntries(offset int64, count uint16) (map[string]*namedEntry, error) {
-	_, err := m.decoder.Seek(offset, os.SEEK_SET)
-	if err != nil {
-		return nil, err
-	}
+func (i *fsInfo) valid() bool {
+	return i.LeadSignature == 0x41615252 && i.StructSignature == 0x61417272 && i.TrailSignature == 0xAA550000
+}
 
-	files := map[string]*namedEntry{}
+// Type is the FAT variant of a volume.
+type Type int
 
-	var currentFilename []byte
-	for i := uint16(0); i < count; i++ {
-		firstByte, err := firstByte(m.decoder)
-		if err != nil {
-			return nil, err
-		}
+// FAT variants, named by the width of their allocation table entries.
+const (
+	FAT12 Type = 12
+	FAT16 Type = 16
+	FAT32 Type = 32
+)
 
-		// if firstByte == 0xe5 { } TODO: Handle deleted files
+func (t Type) String() string {
+	return fmt.Sprintf("FAT%d", int(t))
+}
 
-		// test if entry exists
-		if firstByte != 0x00 {
-			de := directoryEntry{}
+// layout describes where the regions of a FAT volume are located.
+type layout struct {
+	fatType        Type
+	clusterSize    int64
+	fatOffset      int64
+	fatSize        int64
+	fatCount       int64
+	rootOffset     int64
+	rootEntryCount int64
+	rootCluster    uint32
+	dataOffset     int64
+	clusterCount   uint32
+}
 
-			data := make([]byte, 32)
-			_, err = m.decoder.Read(data)
-			if err != nil {
-				return nil, err
-			}
-			m.decoder.Seek(-32, os.SEEK_CUR) // nolint: errcheck
+// newLayout calculates the volume layout and detects the FAT variant from the
+// number of data clusters as described in the Microsoft FAT specification.
+func newLayout(bpb *biosParameterBlock, sectorsPerFat, rootCluster uint32) (*layout, error) {
+	if bpb.SectorSize == 0 || bpb.SectorsPerCluster == 0 || bpb.FatCount <= 0 || sectorsPerFat == 0 {
+		return nil, errors.New("invalid FAT boot sector")
+	}
 
-			err := binary.Read(m.decoder, binary.LittleEndian, &de)
-			if err != nil {
-				return nil, err
-			}
+	sectorSize := int64(bpb.SectorSize)
+	totalSectors := int64(bpb.SectorCountSmall)
+	if totalSectors == 0 {
+		totalSectors = int64(bpb.SectorCountLarge)
+	}
+	rootSectors := (int64(bpb.RootdirEntryCount)*32 + sectorSize - 1) / sectorSize
+
+	l := &layout{
+		clusterSize:    sectorSize * int64(bpb.SectorsPerCluster),
+		fatOffset:      int64(bpb.ReservedSectorCount) * sectorSize,
+		fatSize:        int64(sectorsPerFat) * sectorSize,
+		fatCount:       int64(bpb.FatCount),
+		rootEntryCount: int64(bpb.RootdirEntryCount),
+		rootCluster:    rootCluster,
+	}
+	l.rootOffset = l.fatOffset + l.fatCount*l.fatSize
+	l.dataOffset = l.rootOffset + rootSectors*sectorSize
 
-			// long filename
-			if de.FileAttributes == 0x0F && de.Startingcluster == 0x00 {
-				currentFilename, err = handleLongFilname(data, currentFilename)
-				if err != nil {
-					return nil, err
-				}
-				continue
-			}
+	dataSectors := totalSectors - l.dataOffset/sectorSize
+	if dataSectors <= 0 {
+		return nil, errors.New("invalid FAT boot sector")
+	}
+	l.clusterCount = uint32(dataSectors / int64(bpb.SectorsPerCluster))
+
+	switch {
+	case l.clusterCount < 4085:
+		l.fatType = FAT12
+	case l.clusterCount < 65525:
+		l.fatType = FAT16
+	default:
+		l.fatType = FAT32
+	}
+	return l, nil
+}
 
-			// if de.FileAttributes&0x08 != 0 { } hide volume label
+// clusterOffset returns the volume offset of a data cluster.
+func (l *layout) clusterOffset(cluster uint32) int64 {
+	return l.dataOffset + int64(cluster-2)*l.clusterSize
+}
 
-			// get filename
-			filename := formatFilename(&de)
-			if len(currentFilename) != 0 {
-				filename = strings.TrimRight(utf16BytesToString(currentFilename, binary.LittleEndian), "\x00")
-				currentFilename = []byte{}
+// decodeFAT converts the raw allocation table into one entry per cluster.
+func decodeFAT(fatType Type, data []byte, count uint32) []uint32 {
+	var entries []uint32
+	for i := uint32(0); i < count; i++ {
+		switch fatType {
+		case FAT12:
+			offset := i + i/2
+			if int(offset)+1 >= len(data) {
+				return entries
 			}
-
-			// log.Print("filename ", filename, " ", de.FileAttributes&0x10 != 0, de.Startingcluster, getOffset(int64(de.Startingcluster), m.vh))
-			files[filename] = &namedEntry{name: filename, directoryEntry: de}
-		} else {
-			_, err = m.decoder.Seek(32, os.SEEK_CUR)
-			if err != nil {
-				return nil, err
+			v := uint32(binary.LittleEndian.Uint16(data[offset:]))
+			if i%2 == 1 {
+				v >>= 4
+			}
+			entries = append(entries, v&0xFFF)
+		case FAT16:
+			if int(i)*2+2 > len(data) {
+				return entries
+			}
+			entries = append(entries, uint32(binary.LittleEndian.Uint16(data[i*2:])))
+		case FAT32:
+			if int(i)*4+4 > len(data) {
+				return entries
 			}
+			entries = append(entries, binary.LittleEndian.Uint32(data[i*4:])&0x0FFFFFFF)
 		}
 	}
-	return files, nil
+	return entries
 }
 
-func firstByte(data io.ReadSeeker) (byte, error) {
-	// get first byte
-	firstByteA := make([]byte, 1)
-	n, err := data.Read(firstByteA)
-	if err != nil && err != io.EOF {
-		return 0, err
-	}
-	if n == 0 {
-		return 0, io.EOF
+type lfnEntry struct {
+	SequenceNumber  uint8
+	Filename1       [10]byte
+	Attributes      byte
+	Type            byte
+	Checksum        byte
+	Filename2       [12]byte
+	Startingcluster uint16
+	Filename3       [4]byte
+}
+
+func (m *FS) getDirectoryEntry(dir *namedEntry, name string) (*namedEntry, error) {
+	for _, part := range strings.Split(name, "/") {
+		d, err := m.getDirectory(dir)
+		if err != nil {
+			return nil, err
+		}
+		de, ok := d.entries[part]
+		if !ok {
+			de, ok = d.aliases[part]
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+		}
+		dir = de
 	}
-	_, err = data.Seek(-1, 1)
+	return dir, nil
+}
+
+// dirKey identifies a parsed directory in the directory cache. Deleted
+// directories are read differently, so they are cached separately from live
+// directories that reuse their start cluster.
+type dirKey struct {
+	cluster uint32
+	deleted bool
+}
+
+// directory is a parsed directory. Entries are listed by their long name if
+// available, aliases contains the short names that differ from it.
+type directory struct {
+	entries map[string]*namedEntry
+	aliases map[string]*namedEntry
+}
+
+// getDirectoryEntries returns the entries of a directory. The returned map
+// must not be modified.
+func (m *FS) getDirectoryEntries(dir *namedEntry) (map[string]*namedEntry, error) {
+	d, err := m.getDirectory(dir)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return firstByteA[0], nil
+	return d.entries, nil
 }
 
-func utf16BytesToString(b []byte, o binary.ByteOrder) string {
-	utf := make([]uint16, (len(b)+(2-1))/2)
-	for i := 0; i+(2-1) < len(b); i += 2 {
-		utf[i/2] = o.Uint16(b[i:])
+// getDirectory returns a parsed directory. Parsed directories are cached by
+// their start cluster.
+func (m *FS) getDirectory(dir *namedEntry) (*directory, error) {
+	key := dirKey{cluster: dir.cluster, deleted: dir.deleted}
+	m.dirCacheMu.Lock()
+	d, ok := m.dirCache[key]
+	m.dirCacheMu.Unlock()
+	if ok {
+		return d, nil
 	}
-	if len(b)/2 < len(utf) {
-		utf[len(utf)-1] = utf8.RuneError
+
+	d, err := m.parseDirectory(dir)
+	if err != nil {
+		return nil, err
 	}
-	return string(utf16.Decode(utf))
+
+	m.dirCacheMu.Lock()
+	m.dirCache[key] = d
+	m.dirCacheMu.Unlock()
+	return d, nil
 }
 
-func handleLongFilname(data []byte, currentFilename []byte) ([]byte, error) {
-	lfn := lfnEntry{}
-	err := binary.Read(bytes.NewBuffer(data), binary.LittleEndian, &lfn)
+func (m *FS) parseDirectory(dir *namedEntry) (*directory, error) {
+	d := &directory{entries: map[string]*namedEntry{}, aliases: map[string]*namedEntry{}}
+	if dir.deleted && dir.reallocated {
+		return d, nil
+	}
+
+	// read all clusters of the directory at once
+	r := m.entryReader(dir)
+	buf := make([]byte, r.Size())
+	_, err := r.ReadAt(buf, 0)
 	if err != nil && err != io.EOF {
 		return nil, err
 	}
 
-	lname := append(bytes.TrimRight(lfn.Filename1[:], "\xff"), bytes.TrimRight(lfn.Filename2[:], "\xff")...)
-	lname = append(lname, bytes.TrimRight(lfn.Filename3[:], "\xff")...)
-	currentFilename = append(lname, currentFilename...)
-	return currentFilename, nil
-}
+	var deleted []*namedEntry
+	var live, dead longName
+	var orphans []string
+	for offset := 0; offset+32 <= len(buf); offset += 32 {
+		data := buf[offset : offset+32]
 
-func fatOffset(vh volumeHeader) int64 {
-	return int64(vh.ReservedSectorCount) * int64(vh.SectorSize)
-}
+		// test if entry exists
+		if data[0] == 0x00 {
+			orphans = append(orphans, live.reset()...)
+			orphans = append(orphans, dead.reset()...)
+			continue
+		}
 
-func rootOffset(vh volumeHeader) int64 {
-	return int64(vh.SectorsPerFat)*int64(vh.FatCount)*int64(vh.SectorSize) + fatOffset(vh)
-}
+		isDeleted := data[0] == 0xE5
+		if isDeleted && !m.options.IncludeDeleted {
+			orphans = append(orphans, live.reset()...)
+			continue
+		}
 
-func getOffset(cluster int64, vh volumeHeader) int64 {
-	rootDirSectors := (vh.RootdirEntryCount*32 + (vh.SectorSize - 1)) / vh.SectorSize
+		de := parseDirectoryEntry(data)
 
-	firstDataByte := rootOffset(vh) + int64(rootDirSectors)*int64(vh.SectorSize)
+		// long filename
+		if de.FileAttributes == 0x0F && de.Startingcluster == 0x00 {
+			if isDeleted {
+				orphans = append(orphans, dead.addDeleted(data)...)
+			} else {
+				orphans = append(orphans, live.add(data)...)
+			}
+			continue
+		}
 
-	return ((cluster-2)*int64(vh.SectorsPerCluster))*int64(vh.SectorSize) + firstDataByte
-}
+		// if de.FileAttributes&0x08 != 0 { } hide volume label
 
-/*
-func (m *FS) getVolumeName() (string, error) {
-	rootDirStart := (int64(m.vh.SectorsPerFat)*int64(m.vh.FatCount) + 1) * 512
+		// get filename
+		shortName := data[:11]
+		var long string
+		if isDeleted {
+			orphans = append(orphans, live.reset()...)
+			long, shortName = dead.matchDeleted(shortName)
+		} else {
+			orphans = append(orphans, dead.reset()...)
+			long = live.match(shortName)
+		}
+		orphans = append(orphans, live.reset()...)
+		orphans = append(orphans, dead.reset()...)
+
+		alias := de
+		copy(alias.Filename[:], shortName)
+		entry := &namedEntry{
+			name:           formatFilename(&alias),
+			shortName:      formatFilename(&alias),
+			orphans:        orphans,
+			directoryEntry: de,
+			cluster:        m.startCluster(&de),
+			location:       m.location(),
+		}
+		orphans = nil
+		if long != "" {
+			entry.name = long
+		}
 
-	_, err := decoder.Seek(rootDirStart, os.SEEK_SET)
-	if err != nil {
-		return "", err
+		if !isDeleted && !dir.deleted {
+			d.entries[entry.name] = entry
+			continue
+		}
+
+		// skip self references of deleted directories
+		if isDotEntry(&de) || (de.FileAttributes&0x10 != 0 && entry.cluster == dir.cluster) {
+			continue
+		}
+		entry.deleted = true
+		entry.reallocated = m.reallocated(entry)
+		deleted = append(deleted, entry)
 	}
 
-	for i := uint16(0); i < 5; i++ {
-		firstByte, err := firstByte(decoder)
-		if err != nil {
-			return "", err
+	// deleted entries must not hide live entries with the same name
+	for _, entry := range deleted {
+		name := entry.name
+		for n := 1; d.entries[name] != nil; n++ {
+			name = fmt.Sprintf("%s~%d", entry.name, n)
 		}
+		entry.name = name
+		d.entries[name] = entry
+	}
 
-		// test if entry exists
-		if firstByte != 0x00 {
-			de := directoryEntry{}
+	// short names of live entries take precedence
+	for _, entry := range d.entries {
+		if !entry.deleted {
+			d.addAlias(entry)
+		}
+	}
+	for _, entry := range deleted {
+		d.addAlias(entry)
+	}
+	return d, nil
+}
 
-			err := binary.Read(decoder, binary.LittleEndian, &de)
-			if err != nil {
-				return "", err
-			}
+func (d *directory) addAlias(entry *namedEntry) {
+	if entry.shortName == entry.name {
+		return
+	}
+	if _, ok := d.entries[entry.shortName]; ok {
+		return
+	}
+	if _, ok := d.aliases[entry.shortName]; ok {
+		return
+	}
+	d.aliases[entry.shortName] = entry
+}
 
-			// hide volume label
-			if de.FileAttributes&0x08 != 0 {
-				return formatFilename(&de), nil
-			}
-		}
+// isDotEntry returns if a directory entry is a "." or ".." entry, also if its
+// first character was overwritten on deletion.
+func isDotEntry(de *directoryEntry) bool {
+	if de.FileAttributes&0x10 == 0 {
+		return false
 	}
-	return "", errors.New("Volumename not found")
+	rest := string(de.Filename[1:]) + string(de.FilenameExtension[:])
+	return rest == "          " || rest == ".         "
 }
-*/
 
 /*
 func handleEntry(firstByte byte) {
@@ -23,14 +23,19 @@
 package fat16
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"testing/fstest"
 	"time"
+	"unicode/utf16"
 
 	"github.com/stretchr/testify/assert"
 
@@ -123,3 +128,751 @@ func Test_FAT16(t *testing.T) {
 
 	fslibtest.RunTest(t, "FAT16", "testdata/filesystem/fat16.dd", func(f fsio.ReadSeekerAt) (fs.FS, error) { return New(f) }, tests)
 }
+
+func Test_FragmentedFile(t *testing.T) {
+	tests := []struct {
+		fatType Type
+		offset  uint32
+	}{
+		{FAT12, 0},
+		{FAT16, 0},
+		{FAT32, 65600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fatType.String(), func(t *testing.T) {
+			img := newTestImage(tt.fatType)
+			content := testContent(3*512 + 100)
+			o := tt.offset
+			img.addEntry(0, "FRAG    BIN", 0x20, content, []uint32{o + 10, o + 11, o + 20, o + 5})
+
+			dir := img.addEntry(0, "DIR        ", 0x10, nil, []uint32{o + 30, o + 40})
+			var names []string
+			for i := 0; i < 20; i++ {
+				name := fmt.Sprintf("FILE%02d  TXT", i)
+				img.addEntry(dir, name, 0x20, []byte(name), []uint32{o + 50 + uint32(i)})
+				names = append(names, fmt.Sprintf("FILE%02d.TXT", i))
+			}
+
+			fsys, err := New(img.reader())
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.fatType, fsys.Type())
+			assert.Equal(t, tt.fatType == FAT32, fsys.fsInfo != nil)
+
+			got, err := fs.ReadFile(fsys, "FRAG.BIN")
+			assert.NoError(t, err)
+			assert.Equal(t, content, got)
+
+			f, err := fsys.Open("FRAG.BIN")
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 200)
+			n, err := f.(io.ReaderAt).ReadAt(buf, 512-100)
+			assert.NoError(t, err)
+			assert.Equal(t, 200, n)
+			assert.Equal(t, content[512-100:512+100], buf)
+
+			entries, err := fs.ReadDir(fsys, "DIR")
+			assert.NoError(t, err)
+			var gotNames []string
+			for _, entry := range entries {
+				gotNames = append(gotNames, entry.Name())
+			}
+			assert.Equal(t, names, gotNames)
+
+			got, err = fs.ReadFile(fsys, "DIR/FILE19.TXT")
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("FILE19  TXT"), got)
+
+			assert.NoError(t, fstest.TestFS(fsys, "FRAG.BIN", "DIR/FILE00.TXT", "DIR/FILE19.TXT"))
+		})
+	}
+}
+
+func Test_DeletedEntries(t *testing.T) {
+	for _, fatType := range []Type{FAT12, FAT16, FAT32} {
+		t.Run(fatType.String(), func(t *testing.T) {
+			img := newTestImage(fatType)
+			content := testContent(600)
+			img.addEntry(0, "LIVE    TXT", 0x20, []byte("live"), []uint32{10})
+			img.addEntry(0, "DELETED TXT", 0x20, content, []uint32{20, 21})
+			img.addLongName(0, "Long name.txt", "LONGNA~1TXT", true)
+			img.addEntry(0, "LONGNA~1TXT", 0x20, []byte("long"), []uint32{30})
+			img.addEntry(0, "REUSED  TXT", 0x20, []byte("reused"), []uint32{40})
+			img.addEntry(0, "_OLLIDE TXT", 0x20, []byte("live collide"), []uint32{45})
+			img.addEntry(0, "COLLIDE TXT", 0x20, []byte("deleted collide"), []uint32{46})
+			dir := img.addEntry(0, "OLDDIR     ", 0x10, nil, []uint32{50})
+			img.addEntry(dir, ".          ", 0x10, nil, nil)
+			img.addEntry(dir, "..         ", 0x10, nil, nil)
+			img.addEntry(dir, "CHILD   TXT", 0x20, []byte("child"), []uint32{51})
+
+			img.deleteEntry(0, "DELETED TXT")
+			img.deleteEntry(0, "LONGNA~1TXT")
+			img.deleteEntry(0, "REUSED  TXT")
+			img.addEntry(0, "OTHER   TXT", 0x20, []byte("other!"), []uint32{40})
+			img.deleteEntry(0, "COLLIDE TXT")
+			img.deleteEntry(dir, "CHILD   TXT")
+			img.deleteEntry(0, "OLDDIR     ")
+
+			fsys, err := New(img.reader())
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries, err := fs.ReadDir(fsys, ".")
+			assert.NoError(t, err)
+			assert.Len(t, entries, 3)
+
+			fsys, err = NewWithOptions(img.reader(), Options{IncludeDeleted: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries, err = fs.ReadDir(fsys, ".")
+			assert.NoError(t, err)
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			assert.Equal(t, []string{"LIVE.TXT", "Long name.txt", "OTHER.TXT", "_ELETED.TXT", "_EUSED.TXT", "_LDDIR", "_OLLIDE.TXT", "_OLLIDE.TXT~1"}, names)
+
+			got, err := fs.ReadFile(fsys, "_ELETED.TXT")
+			assert.NoError(t, err)
+			assert.Equal(t, content, got)
+
+			got, err = fs.ReadFile(fsys, "_OLLIDE.TXT~1")
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("deleted collide"), got)
+
+			got, err = fs.ReadFile(fsys, "_LDDIR/_HILD.TXT")
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("child"), got)
+
+			tests := []struct {
+				name string
+				want EntryInfo
+			}{
+				{"LIVE.TXT", EntryInfo{Attributes: 0x20, FirstCluster: 10, ShortName: "LIVE.TXT"}},
+				{"Long name.txt", EntryInfo{Attributes: 0x20, FirstCluster: 30, Deleted: true, ShortName: "LONGNA~1.TXT"}},
+				{"_EUSED.TXT", EntryInfo{Attributes: 0x20, FirstCluster: 40, Deleted: true, Reallocated: true, ShortName: "_EUSED.TXT"}},
+				{"_LDDIR", EntryInfo{Attributes: 0x10, FirstCluster: 50, Deleted: true, ShortName: "_LDDIR"}},
+				{"_LDDIR/_HILD.TXT", EntryInfo{Attributes: 0x20, FirstCluster: 51, Deleted: true, ShortName: "_HILD.TXT"}},
+				{"LONGNA~1.TXT", EntryInfo{Attributes: 0x20, FirstCluster: 30, Deleted: true, ShortName: "LONGNA~1.TXT"}},
+			}
+			for _, tt := range tests {
+				info, err := fs.Stat(fsys, tt.name)
+				if assert.NoError(t, err, tt.name) {
+					assert.Equal(t, &tt.want, info.Sys(), tt.name)
+				}
+			}
+
+			assert.NoError(t, fstest.TestFS(fsys, "LIVE.TXT", "_ELETED.TXT", "_LDDIR/_HILD.TXT"))
+		})
+	}
+}
+
+func Test_Timestamps(t *testing.T) {
+	img := newTestImage(FAT16)
+	img.addEntry(0, "TIME    TXT", 0x20, []byte("time"), []uint32{10})
+	offset := img.findSlot(0, "TIME    TXT")
+	de := directoryEntry{}
+	if err := binary.Read(bytes.NewReader(img.data[offset:offset+32]), binary.LittleEndian, &de); err != nil {
+		t.Fatal(err)
+	}
+	de.CreationDate = (2019-1980)<<9 | 12<<5 | 24   // 2019-12-24
+	de.CreationTime = 18<<11 | 30<<5 | 29           // 18:30:58
+	de.CreationTimeFine = 155                       // +1.55s
+	de.ModificationDate = (2021-1980)<<9 | 1<<5 | 2 // 2021-01-02
+	de.ModificationTime = 3<<11 | 4<<5 | 5          // 03:04:10
+	de.AccessDate = (2021-1980)<<9 | 2<<5 | 3       // 2021-02-03
+	img.writeSlot(offset, &de)
+
+	loc := time.FixedZone("CET", 3600)
+	fsys, err := NewWithOptions(img.reader(), Options{Location: loc})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := fs.Stat(fsys, "TIME.TXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &EntryInfo{
+		Attributes:   0x20,
+		FirstCluster: 10,
+		ShortName:    "TIME.TXT",
+		Created:      time.Date(2019, 12, 24, 18, 30, 59, 550000000, loc),
+		Modified:     time.Date(2021, 1, 2, 3, 4, 10, 0, loc),
+		Accessed:     time.Date(2021, 2, 3, 0, 0, 0, 0, loc),
+	}
+	assert.Equal(t, want, info.Sys())
+	assert.Equal(t, want.Modified, info.ModTime())
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if assert.NoError(t, err) && assert.Len(t, entries, 1) {
+		info, err = entries[0].Info()
+		assert.NoError(t, err)
+		assert.Equal(t, want.Modified, info.ModTime())
+	}
+
+	fsys, err = New(img.reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = fs.Stat(fsys, "TIME.TXT")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 1, 2, 3, 4, 10, 0, time.UTC), info.ModTime())
+}
+
+func Test_dosTime(t *testing.T) {
+	assert.True(t, dosTime(0, 0, 0, time.UTC).IsZero())
+	assert.True(t, dosTime(13<<5|1, 0, 0, time.UTC).IsZero())
+	assert.Equal(t, time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC), dosTime(1<<5|1, 0, 0, time.UTC))
+}
+
+func Test_VolumeInfo(t *testing.T) {
+	tests := []struct {
+		fatType Type
+		want    VolumeInfo
+	}{
+		{FAT12, VolumeInfo{SectorCount: 2880, ClusterSize: 512, TotalClusters: 2847, FreeClusters: 2846, FSInfoFreeClusters: -1}},
+		{FAT16, VolumeInfo{SectorCount: 8192, ClusterSize: 512, TotalClusters: 8095, FreeClusters: 8094, FSInfoFreeClusters: -1}},
+		{FAT32, VolumeInfo{SectorCount: 67674, ClusterSize: 512, TotalClusters: 66600, FreeClusters: 66598, FSInfoFreeClusters: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fatType.String(), func(t *testing.T) {
+			img := newTestImage(tt.fatType)
+			img.addEntry(0, "EVIDENCE   ", 0x08, nil, nil)
+			img.addEntry(0, "FILE    TXT", 0x20, []byte("file"), []uint32{10})
+
+			fsys, err := New(img.reader())
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			want.Type = tt.fatType
+			want.OEMName = "MSWIN4.1"
+			want.Label = "NO NAME"
+			want.RootLabel = "EVIDENCE"
+			want.SerialNumber = 0x12345678
+			want.SectorSize = 512
+			want.FATCount = 2
+
+			info, err := fsys.VolumeInfo()
+			assert.NoError(t, err)
+			assert.Equal(t, &want, info)
+		})
+	}
+}
+
+func Test_Check(t *testing.T) {
+	img := newTestImage(FAT16)
+	img.addEntry(0, "A       TXT", 0x20, testContent(600), []uint32{10, 11})
+	dir := img.addEntry(0, "DIR        ", 0x10, nil, []uint32{12})
+	img.addEntry(dir, "E       TXT", 0x20, testContent(100), []uint32{13})
+
+	fsys, err := New(img.reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := fsys.Check()
+	assert.NoError(t, err)
+	assert.True(t, report.OK())
+
+	img.addEntry(dir, "B       TXT", 0x20, testContent(600), []uint32{20, 11})
+	img.addEntry(0, "C       TXT", 0x20, testContent(100), []uint32{30, 31})
+	img.addEntry(0, "D       TXT", 0x20, testContent(1024), []uint32{40, 41})
+	img.fat[41] = 40
+	r := img.reader()
+	binary.LittleEndian.PutUint16(img.data[img.layout.fatOffset+img.layout.fatSize+50*2:], 0xFFFF)
+
+	fsys, err = New(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = fsys.Check()
+	assert.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, &CheckReport{
+		FATDifferences: []FATDifference{{Cluster: 50, Values: []uint32{0, 0xFFFF}}},
+		CrossLinks:     []CrossLink{{Cluster: 11, Paths: []string{"A.TXT", "DIR/B.TXT"}}},
+		Loops:          []Loop{{Path: "D.TXT", Cluster: 40}},
+		SizeMismatches: []SizeMismatch{{Path: "C.TXT", Size: 100, Clusters: 2, ExpectedClusters: 1}},
+	}, report)
+}
+
+func Test_SlackAndUnallocated(t *testing.T) {
+	img := newTestImage(FAT16)
+	img.addEntry(0, "SLACK   TXT", 0x20, testContent(600), []uint32{10, 11})
+	img.addEntry(0, "EMPTY   TXT", 0x20, nil, nil)
+	slack := bytes.Repeat([]byte("S"), 512-88)
+	copy(img.data[img.layout.clusterOffset(11)+88:], slack)
+	copy(img.data[img.layout.clusterOffset(12):], "free")
+
+	fsys, err := New(img.reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fsys.Open("SLACK.TXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := f.(*Item).Slack()
+	assert.NoError(t, err)
+	got, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, slack, got)
+
+	f, err = fsys.Open("EMPTY.TXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = f.(*Item).Slack()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), r.Size())
+
+	f, err = fsys.Open(UnallocatedName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := f.Stat()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8093)*512, info.Size())
+
+	u := f.(*Unallocated)
+	buf := make([]byte, 4)
+	_, err = u.ReadAt(buf, 8*512)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("free"), buf)
+
+	cluster, offset, ok := u.Cluster(8*512 + 5)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(12), cluster)
+	assert.Equal(t, int64(5), offset)
+
+	volumeOffset, ok := u.VolumeOffset(8*512 + 5)
+	assert.True(t, ok)
+	assert.Equal(t, img.layout.clusterOffset(12)+5, volumeOffset)
+
+	_, _, ok = u.Cluster(info.Size())
+	assert.False(t, ok)
+}
+
+func Test_UnallocatedNameCollision(t *testing.T) {
+	img := newTestImage(FAT16)
+	img.addLongName(0, UnallocatedName, "UNALLO~1   ", false)
+	img.addEntry(0, "UNALLO~1   ", 0x20, []byte("real"), []uint32{10})
+
+	fsys, err := New(img.reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fs.ReadFile(fsys, UnallocatedName)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("real"), got)
+	assert.NotNil(t, fsys.Unallocated())
+
+	_, err = fsys.Open("MISSING.TXT")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	// read errors of the root directory are not hidden by the virtual file
+	img = newTestImage(FAT16)
+	fsys, err = New(&failingReader{Reader: img.reader(), from: img.layout.rootOffset})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fsys.Open(UnallocatedName)
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, fs.ErrNotExist))
+}
+
+// failingReader fails all reads from offset from on.
+type failingReader struct {
+	*bytes.Reader
+	from int64
+}
+
+func (r *failingReader) ReadAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > r.from {
+		return 0, errors.New("read error")
+	}
+	return r.Reader.ReadAt(p, off)
+}
+
+func Test_Concurrent(t *testing.T) {
+	img := newTestImage(FAT32)
+	dir := img.addEntry(0, "DIR        ", 0x10, nil, []uint32{3, 9})
+	var contents [][]byte
+	for i := 0; i < 20; i++ {
+		content := testContent(700 + i)
+		contents = append(contents, content)
+		c := uint32(100 + i*4)
+		img.addEntry(dir, fmt.Sprintf("FILE%02d  BIN", i), 0x20, content, []uint32{c, c + 2})
+	}
+
+	fsys, err := New(img.reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error)
+	for i := 0; i < 20; i++ {
+		go func(i int) {
+			for j := 0; j < 10; j++ {
+				name := fmt.Sprintf("DIR/FILE%02d.BIN", (i+j)%20)
+				got, err := fs.ReadFile(fsys, name)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !bytes.Equal(contents[(i+j)%20], got) {
+					errs <- fmt.Errorf("wrong content for %s", name)
+					return
+				}
+				entries, err := fs.ReadDir(fsys, "DIR")
+				if err != nil {
+					errs <- err
+					return
+				}
+				if len(entries) != 20 {
+					errs <- fmt.Errorf("wrong number of entries %d", len(entries))
+					return
+				}
+			}
+			errs <- nil
+		}(i)
+	}
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, <-errs)
+	}
+}
+
+type countingReader struct {
+	*bytes.Reader
+	reads int64
+}
+
+func (r *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	atomic.AddInt64(&r.reads, 1)
+	return r.Reader.ReadAt(p, off)
+}
+
+func Test_DirectoryCache(t *testing.T) {
+	img := newTestImage(FAT16)
+	a := img.addEntry(0, "A          ", 0x10, nil, []uint32{10, 20})
+	b := img.addEntry(a, "B          ", 0x10, nil, []uint32{11})
+	img.addEntry(b, "FILE    TXT", 0x20, []byte("deep"), []uint32{12})
+
+	r := &countingReader{Reader: img.reader()}
+	fsys, err := New(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reads := atomic.LoadInt64(&r.reads)
+	_, err = fsys.Open("A/B/FILE.TXT")
+	assert.NoError(t, err)
+	// one read per directory, the fragmented directory A is read in two parts
+	assert.Equal(t, int64(4), atomic.LoadInt64(&r.reads)-reads)
+
+	reads = atomic.LoadInt64(&r.reads)
+	_, err = fsys.Open("A/B/FILE.TXT")
+	assert.NoError(t, err)
+	_, err = fs.ReadDir(fsys, "A/B")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), atomic.LoadInt64(&r.reads)-reads)
+
+	got, err := fs.ReadFile(fsys, "A/B/FILE.TXT")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("deep"), got)
+}
+
+func Test_LongFilenames(t *testing.T) {
+	img := newTestImage(FAT16)
+	img.addLongName(0, "A long file name.txt", "ALONGF~1TXT", false)
+	img.addEntry(0, "ALONGF~1TXT", 0x20, []byte("long"), []uint32{10})
+	img.addLongName(0, "Tampered.txt", "OTHER   TXT", false)
+	img.addEntry(0, "WRONG   TXT", 0x20, []byte("wrong"), []uint32{11})
+	img.addLongName(0, "Broken sequence name.txt", "BROKEN  TXT", false)
+	img.data[img.freeSlot(0)-32] = 0x03 // second entry with wrong ordinal
+	img.addEntry(0, "BROKEN  TXT", 0x20, []byte("broken"), []uint32{12})
+
+	fsys, err := New(img.reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	assert.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"A long file name.txt", "BROKEN.TXT", "WRONG.TXT"}, names)
+
+	got, err := fs.ReadFile(fsys, "ALONGF~1.TXT")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("long"), got)
+
+	tests := []struct {
+		name      string
+		shortName string
+		orphans   []string
+	}{
+		{"A long file name.txt", "ALONGF~1.TXT", nil},
+		{"ALONGF~1.TXT", "ALONGF~1.TXT", nil},
+		{"WRONG.TXT", "WRONG.TXT", []string{"Tampered.txt"}},
+		{"BROKEN.TXT", "BROKEN.TXT", []string{"ce name.txt", "Broken sequen"}},
+	}
+	for _, tt := range tests {
+		info, err := fs.Stat(fsys, tt.name)
+		if assert.NoError(t, err, tt.name) {
+			sys := info.Sys().(*EntryInfo)
+			assert.Equal(t, tt.shortName, sys.ShortName, tt.name)
+			assert.Equal(t, tt.orphans, sys.OrphanLongNames, tt.name)
+		}
+	}
+
+	assert.NoError(t, fstest.TestFS(fsys, "A long file name.txt", "WRONG.TXT", "BROKEN.TXT"))
+}
+
+func testContent(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i * 7 / 3)
+	}
+	return content
+}
+
+// testImage builds small FAT volumes in memory.
+type testImage struct {
+	bpb    biosParameterBlock
+	layout *layout
+	data   []byte
+	fat    []uint32
+}
+
+func newTestImage(fatType Type) *testImage {
+	bpb := biosParameterBlock{
+		JumpInstruction:     [3]byte{0xEB, 0x3C, 0x90},
+		SectorSize:          512,
+		SectorsPerCluster:   1,
+		ReservedSectorCount: 1,
+		FatCount:            2,
+		MediaID:             0xF8,
+	}
+	copy(bpb.CreatingSystemID[:], "MSWIN4.1")
+
+	var sectorsPerFat, rootCluster uint32
+	switch fatType {
+	case FAT12:
+		bpb.RootdirEntryCount = 224
+		bpb.SectorCountSmall = 2880
+		sectorsPerFat = 9
+	case FAT16:
+		bpb.RootdirEntryCount = 512
+		bpb.SectorCountSmall = 8192
+		sectorsPerFat = 32
+	case FAT32:
+		bpb.ReservedSectorCount = 32
+		bpb.SectorCountLarge = 67674
+		sectorsPerFat = 521
+		rootCluster = 2
+	}
+
+	l, err := newLayout(&bpb, sectorsPerFat, rootCluster)
+	if err != nil {
+		panic(err)
+	}
+	if l.fatType != fatType {
+		panic("wrong FAT type " + l.fatType.String())
+	}
+
+	img := &testImage{
+		bpb:    bpb,
+		layout: l,
+		data:   make([]byte, (int64(bpb.SectorCountSmall)+int64(bpb.SectorCountLarge))*512),
+		fat:    make([]uint32, l.clusterCount+2),
+	}
+	img.fat[0] = 0x0FFFFFF8
+	img.fat[1] = 0x0FFFFFFF
+	if rootCluster != 0 {
+		img.fat[rootCluster] = 0x0FFFFFFF
+	}
+	return img
+}
+
+// addEntry writes content into the given clusters, links them in the FAT and
+// adds a directory entry in the directory starting at dir. It returns the
+// first cluster.
+func (img *testImage) addEntry(dir uint32, name string, attr byte, content []byte, clusters []uint32) uint32 {
+	clusterSize := int(img.layout.clusterSize)
+	for i, cluster := range clusters {
+		img.fat[cluster] = 0x0FFFFFFF
+		if i > 0 {
+			img.fat[clusters[i-1]] = cluster
+		}
+		if i*clusterSize < len(content) {
+			copy(img.data[img.layout.clusterOffset(cluster):], content[i*clusterSize:])
+		}
+	}
+
+	de := directoryEntry{FileAttributes: attr, FileSize: uint32(len(content))}
+	copy(de.Filename[:], name[:8])
+	copy(de.FilenameExtension[:], name[8:])
+	var first uint32
+	if len(clusters) > 0 {
+		first = clusters[0]
+		de.Startingcluster = uint16(first)
+		de.StartingclusterHi = uint16(first >> 16)
+	}
+	if attr&0x10 != 0 {
+		de.FileSize = 0
+	}
+
+	img.writeSlot(img.freeSlot(dir), &de)
+	return first
+}
+
+// deleteEntry marks the directory entry with the given name as deleted and
+// frees its clusters.
+func (img *testImage) deleteEntry(dir uint32, name string) {
+	offset := img.findSlot(dir, name)
+	de := directoryEntry{}
+	if err := binary.Read(bytes.NewReader(img.data[offset:offset+32]), binary.LittleEndian, &de); err != nil {
+		panic(err)
+	}
+	cluster := uint32(de.StartingclusterHi)<<16 | uint32(de.Startingcluster)
+	for cluster >= 2 && cluster < 0x0FFFFFF8 {
+		next := img.fat[cluster]
+		img.fat[cluster] = 0
+		cluster = next
+	}
+	img.data[offset] = 0xE5
+}
+
+func (img *testImage) slots(dir uint32) []int64 {
+	var offsets []int64
+	if dir == 0 && img.layout.fatType != FAT32 {
+		for i := int64(0); i < img.layout.rootEntryCount; i++ {
+			offsets = append(offsets, img.layout.rootOffset+i*32)
+		}
+	} else {
+		if dir == 0 {
+			dir = img.layout.rootCluster
+		}
+		for cluster := dir; cluster < 0x0FFFFFF8; cluster = img.fat[cluster] {
+			for i := int64(0); i < img.layout.clusterSize; i += 32 {
+				offsets = append(offsets, img.layout.clusterOffset(cluster)+i)
+			}
+		}
+	}
+	return offsets
+}
+
+func (img *testImage) findSlot(dir uint32, name string) int64 {
+	for _, offset := range img.slots(dir) {
+		if string(img.data[offset:offset+11]) == name {
+			return offset
+		}
+	}
+	panic("entry not found")
+}
+
+func (img *testImage) freeSlot(dir uint32) int64 {
+	for _, offset := range img.slots(dir) {
+		if img.data[offset] == 0x00 {
+			return offset
+		}
+	}
+	panic("directory full")
+}
+
+// addLongName writes the long file name entries of name in physical order.
+// Deleted entries have their sequence number overwritten with 0xE5.
+func (img *testImage) addLongName(dir uint32, name, shortName string, deleted bool) {
+	chars := utf16.Encode([]rune(name))
+	if len(chars)%13 != 0 {
+		chars = append(chars, 0)
+	}
+	for len(chars)%13 != 0 {
+		chars = append(chars, 0xFFFF)
+	}
+
+	count := len(chars) / 13
+	for ordinal := count; ordinal > 0; ordinal-- {
+		part := make([]byte, 26)
+		for i, c := range chars[(ordinal-1)*13 : ordinal*13] {
+			binary.LittleEndian.PutUint16(part[i*2:], c)
+		}
+		lfn := &lfnEntry{SequenceNumber: uint8(ordinal), Attributes: 0x0F, Checksum: shortNameChecksum([]byte(shortName))}
+		if ordinal == count {
+			lfn.SequenceNumber |= 0x40
+		}
+		if deleted {
+			lfn.SequenceNumber = 0xE5
+		}
+		copy(lfn.Filename1[:], part[:10])
+		copy(lfn.Filename2[:], part[10:22])
+		copy(lfn.Filename3[:], part[22:])
+		img.writeSlot(img.freeSlot(dir), lfn)
+	}
+}
+
+func (img *testImage) writeSlot(offset int64, v interface{}) {
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, binary.LittleEndian, v); err != nil {
+		panic(err)
+	}
+	copy(img.data[offset:], buf.Bytes())
+}
+
+func (img *testImage) reader() *bytes.Reader {
+	ebr := extendedBootRecord{ExtendedBootSignature: 0x29, VolumeID: [4]byte{0x78, 0x56, 0x34, 0x12}}
+	copy(ebr.VolumeLabel[:], "NO NAME    ")
+	copy(ebr.FsType[:], img.layout.fatType.String()+"   ")
+
+	if img.layout.fatType == FAT32 {
+		vh := fat32VolumeHeader{
+			biosParameterBlock: img.bpb,
+			SectorsPerFat32:    uint32(img.layout.fatSize / 512),
+			RootCluster:        img.layout.rootCluster,
+			FsInfoSector:       1,
+			extendedBootRecord: ebr,
+		}
+		vh.BootSectorSignature = [2]byte{0x55, 0xAA}
+		img.writeSlot(0, &vh)
+		img.writeSlot(512, &fsInfo{LeadSignature: 0x41615252, StructSignature: 0x61417272, FreeCount: 0xFFFFFFFF, NextFree: 0xFFFFFFFF, TrailSignature: 0xAA550000})
+	} else {
+		bpb := img.bpb
+		bpb.SectorsPerFat = uint16(img.layout.fatSize / 512)
+		vh := volumeHeader{biosParameterBlock: bpb, extendedBootRecord: ebr}
+		vh.BootSectorSignature = [2]byte{0x55, 0xAA}
+		img.writeSlot(0, &vh)
+	}
+
+	fat := make([]byte, img.layout.fatSize)
+	for i, v := range img.fat {
+		switch img.layout.fatType {
+		case FAT12:
+			offset := i + i/2
+			if i%2 == 1 {
+				fat[offset] = fat[offset]&0x0F | byte(v<<4)
+				fat[offset+1] = byte(v >> 4)
+			} else {
+				fat[offset] = byte(v)
+				fat[offset+1] = fat[offset+1]&0xF0 | byte(v>>8)&0x0F
+			}
+		case FAT16:
+			binary.LittleEndian.PutUint16(fat[i*2:], uint16(v))
+		case FAT32:
+			binary.LittleEndian.PutUint32(fat[i*4:], v&0x0FFFFFFF)
+		}
+	}
+	for i := int64(0); i < img.layout.fatCount; i++ {
+		copy(img.data[img.layout.fatOffset+i*img.layout.fatSize:], fat)
+	}
+	return bytes.NewReader(img.data)
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package fat16
+
+import (
+	"errors"
+	"io"
+	"sort"
+)
+
+// extent describes a run of consecutive clusters on the volume.
+type extent struct {
+	start  int64 // offset in the cluster chain
+	offset int64 // offset on the volume
+	length int64
+}
+
+// chainReader provides a contiguous view on the clusters of a cluster chain.
+type chainReader struct {
+	r       io.ReaderAt
+	extents []extent
+	size    int64
+}
+
+func newChainReader(r io.ReaderAt, extents []extent) *chainReader {
+	var size int64
+	for i := range extents {
+		extents[i].start = size
+		size += extents[i].length
+	}
+	return &chainReader{r: r, extents: extents, size: size}
+}
+
+// Size returns the number of bytes in all clusters of the chain.
+func (c *chainReader) Size() int64 { return c.size }
+
+// ReadAt reads len(p) bytes starting at offset off of the cluster chain.
+func (c *chainReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= c.size {
+		return 0, io.EOF
+	}
+
+	// find the first extent that contains off
+	idx := sort.Search(len(c.extents), func(i int) bool {
+		return c.extents[i].start+c.extents[i].length > off
+	})
+
+	for ; idx < len(c.extents) && n < len(p); idx++ {
+		e := c.extents[idx]
+		rel := off + int64(n) - e.start
+		toRead := e.length - rel
+		if toRead > int64(len(p)-n) {
+			toRead = int64(len(p) - n)
+		}
+
+		m, err := c.r.ReadAt(p[n:n+int(toRead)], e.offset+rel)
+		n += m
+		if err != nil && !(err == io.EOF && int64(m) == toRead) {
+			return n, err
+		}
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// startCluster returns the first cluster of a directory entry. The high word
+// of the cluster number is only used on FAT32.
+func (m *FS) startCluster(de *directoryEntry) uint32 {
+	if m.layout.fatType == FAT32 {
+		return uint32(de.StartingclusterHi)<<16 | uint32(de.Startingcluster)
+	}
+	return uint32(de.Startingcluster)
+}
+
+// validCluster returns if cluster refers to a data cluster of the volume.
+// End-of-chain markers and bad cluster markers are always out of this range.
+func (m *FS) validCluster(cluster uint32) bool {
+	return cluster >= 2 && cluster-2 < m.layout.clusterCount && int(cluster) < len(m.fat)
+}
+
+// chain returns the cluster numbers of the cluster chain starting at cluster.
+// If limit is greater than zero at most limit clusters are returned, otherwise
+// the walk stops at clusters that were already visited to prevent endless
+// loops.
+func (m *FS) chain(cluster uint32, limit int64) []uint32 {
+	var clusters []uint32
+	var seen map[uint32]bool
+	if limit <= 0 {
+		seen = map[uint32]bool{}
+	}
+	for m.validCluster(cluster) && (limit <= 0 || int64(len(clusters)) < limit) {
+		if seen != nil {
+			if seen[cluster] {
+				break
+			}
+			seen[cluster] = true
+		}
+		clusters = append(clusters, cluster)
+		cluster = m.fat[cluster]
+	}
+	return clusters
+}
+
+// chainReader returns a reader for the cluster chain starting at cluster. If
+// size is greater than zero only the clusters required to hold size bytes are
+// included.
+func (m *FS) chainReader(cluster uint32, size int64) *chainReader {
+	clusterSize := m.layout.clusterSize
+
+	var limit int64
+	if size > 0 {
+		limit = (size + clusterSize - 1) / clusterSize
+	}
+
+	var extents []extent
+	for _, c := range m.chain(cluster, limit) {
+		offset := m.layout.clusterOffset(c)
+		if len(extents) > 0 {
+			last := &extents[len(extents)-1]
+			if last.offset+last.length == offset {
+				last.length += clusterSize
+				continue
+			}
+		}
+		extents = append(extents, extent{offset: offset, length: clusterSize})
+	}
+	return newChainReader(m.decoder, extents)
+}
+
+// directoryReader returns a reader for the directory slots of the directory
+// starting at cluster. Cluster 0 refers to the root directory, which is a
+// fixed region on FAT12 and FAT16 and a cluster chain on FAT32.
+func (m *FS) directoryReader(cluster uint32) *io.SectionReader {
+	if cluster == 0 {
+		if m.layout.fatType != FAT32 {
+			return io.NewSectionReader(m.decoder, m.layout.rootOffset, m.layout.rootEntryCount*32)
+		}
+		cluster = m.layout.rootCluster
+	}
+	r := m.chainReader(cluster, 0)
+	return io.NewSectionReader(r, 0, r.Size())
+}
+
+// contiguousReader returns a reader for size bytes starting at cluster. The
+// FAT entries of deleted files are cleared, so the clusters are assumed to be
+// allocated contiguously.
+func (m *FS) contiguousReader(cluster uint32, size int64) *chainReader {
+	var extents []extent
+	if m.validCluster(cluster) && size > 0 {
+		length := m.contiguousClusters(cluster, size) * m.layout.clusterSize
+		extents = append(extents, extent{offset: m.layout.clusterOffset(cluster), length: length})
+	}
+	return newChainReader(m.decoder, extents)
+}
+
+// contiguousClusters returns the number of clusters that are required to hold
+// size bytes starting at cluster without exceeding the data region.
+func (m *FS) contiguousClusters(cluster uint32, size int64) int64 {
+	count := (size + m.layout.clusterSize - 1) / m.layout.clusterSize
+	if available := int64(m.layout.clusterCount) + 2 - int64(cluster); count > available {
+		count = available
+	}
+	return count
+}
+
+// reallocated returns if any of the clusters assumed for a deleted entry is
+// allocated again.
+func (m *FS) reallocated(entry *namedEntry) bool {
+	if !m.validCluster(entry.cluster) {
+		return false
+	}
+	count := m.contiguousClusters(entry.cluster, entry.contentSize(m.layout.clusterSize))
+	for i := int64(0); i < count; i++ {
+		c := entry.cluster + uint32(i)
+		if int(c) < len(m.fat) && m.fat[c] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// entryReader returns a reader for the content of a directory entry. Deleted
+// entries are read with contiguousReader.
+func (m *FS) entryReader(entry *namedEntry) *io.SectionReader {
+	switch {
+	case entry.deleted:
+		size := entry.contentSize(m.layout.clusterSize)
+		return io.NewSectionReader(m.contiguousReader(entry.cluster, size), 0, size)
+	case entry.IsDir():
+		return m.directoryReader(entry.cluster)
+	default:
+		size := int64(entry.FileSize)
+		return io.NewSectionReader(m.chainReader(entry.cluster, size), 0, size)
+	}
+}
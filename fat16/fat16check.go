@@ -0,0 +1,214 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package fat16
+
+import (
+	"io"
+	"path"
+	"sort"
+)
+
+// CheckReport lists inconsistencies between the copies of the file allocation
+// table and between the FAT and the directory tree. Such inconsistencies are
+// caused by file system corruption or manual tampering.
+type CheckReport struct {
+	FATDifferences []FATDifference
+	CrossLinks     []CrossLink
+	Loops          []Loop
+	SizeMismatches []SizeMismatch
+}
+
+// OK returns if no inconsistencies were found.
+func (r *CheckReport) OK() bool {
+	return len(r.FATDifferences) == 0 && len(r.CrossLinks) == 0 && len(r.Loops) == 0 && len(r.SizeMismatches) == 0
+}
+
+// FATDifference is a cluster whose entries differ between the FAT copies.
+// Values contains the entry of each copy.
+type FATDifference struct {
+	Cluster uint32
+	Values  []uint32
+}
+
+// CrossLink is a cluster that is part of the cluster chains of multiple
+// files or directories.
+type CrossLink struct {
+	Cluster uint32
+	Paths   []string
+}
+
+// Loop is a cluster chain that links back to one of its own clusters.
+type Loop struct {
+	Path    string
+	Cluster uint32 // first cluster that is visited twice
+}
+
+// SizeMismatch is a file whose cluster chain does not match the file size of
+// its directory entry.
+type SizeMismatch struct {
+	Path             string
+	Size             int64
+	Clusters         int64
+	ExpectedClusters int64
+}
+
+// Check compares all copies of the file allocation table and validates the
+// cluster chains of all files and directories.
+func (m *FS) Check() (*CheckReport, error) {
+	report := &CheckReport{}
+
+	differences, err := m.compareFATs()
+	if err != nil {
+		return nil, err
+	}
+	report.FATDifferences = differences
+
+	c := &checker{fs: m, report: report, owners: map[uint32][]string{}, dirs: map[uint32]bool{}}
+	root := m.root()
+	if m.layout.fatType == FAT32 {
+		c.checkChain(".", m.layout.rootCluster, -1)
+	}
+	err = c.walk(".", root)
+	if err != nil {
+		return nil, err
+	}
+
+	var clusters []int
+	for cluster, paths := range c.owners {
+		if len(paths) > 1 {
+			clusters = append(clusters, int(cluster))
+		}
+	}
+	sort.Ints(clusters)
+	for _, cluster := range clusters {
+		report.CrossLinks = append(report.CrossLinks, CrossLink{Cluster: uint32(cluster), Paths: c.owners[uint32(cluster)]})
+	}
+	return report, nil
+}
+
+// compareFATs returns the clusters whose entries differ between the FAT
+// copies.
+func (m *FS) compareFATs() ([]FATDifference, error) {
+	base := int64(m.bpb.ReservedSectorCount) * int64(m.bpb.SectorSize)
+	count := m.layout.clusterCount + 2
+
+	var fats [][]uint32
+	for i := int64(0); i < m.layout.fatCount; i++ {
+		data := make([]byte, m.layout.fatSize)
+		_, err := m.decoder.ReadAt(data, base+i*m.layout.fatSize)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		fats = append(fats, decodeFAT(m.layout.fatType, data, count))
+	}
+
+	var differences []FATDifference
+	for cluster := 0; cluster < len(fats[0]); cluster++ {
+		values := make([]uint32, len(fats))
+		differs := false
+		for i, fat := range fats {
+			if cluster < len(fat) {
+				values[i] = fat[cluster]
+			}
+			if values[i] != values[0] {
+				differs = true
+			}
+		}
+		if differs {
+			differences = append(differences, FATDifference{Cluster: uint32(cluster), Values: values})
+		}
+	}
+	return differences, nil
+}
+
+type checker struct {
+	fs     *FS
+	report *CheckReport
+	owners map[uint32][]string
+	dirs   map[uint32]bool
+}
+
+func (c *checker) walk(name string, dir *namedEntry) error {
+	entries, err := c.fs.getDirectoryEntries(dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for entryName, entry := range entries {
+		if entryName == "." || entryName == ".." || entry.deleted || entry.FileAttributes&0x08 != 0 {
+			continue
+		}
+		names = append(names, entryName)
+	}
+	sort.Strings(names)
+
+	for _, entryName := range names {
+		entry := entries[entryName]
+		entryPath := path.Join(name, entryName)
+		if !entry.IsDir() {
+			c.checkChain(entryPath, entry.cluster, int64(entry.FileSize))
+			continue
+		}
+
+		c.checkChain(entryPath, entry.cluster, -1)
+		// directories that link to an ancestor are only reported as cross-link
+		if entry.cluster == 0 || c.dirs[entry.cluster] {
+			continue
+		}
+		c.dirs[entry.cluster] = true
+		err = c.walk(entryPath, entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkChain follows the cluster chain starting at cluster and records its
+// clusters, loops and, if size is not negative, size mismatches.
+func (c *checker) checkChain(name string, cluster uint32, size int64) {
+	seen := map[uint32]bool{}
+	var count int64
+	for c.fs.validCluster(cluster) {
+		if seen[cluster] {
+			c.report.Loops = append(c.report.Loops, Loop{Path: name, Cluster: cluster})
+			break
+		}
+		seen[cluster] = true
+		c.owners[cluster] = append(c.owners[cluster], name)
+		count++
+		cluster = c.fs.fat[cluster]
+	}
+
+	if size < 0 {
+		return
+	}
+	clusterSize := c.fs.layout.clusterSize
+	expected := (size + clusterSize - 1) / clusterSize
+	if count != expected {
+		c.report.SizeMismatches = append(c.report.SizeMismatches, SizeMismatch{
+			Path: name, Size: size, Clusters: count, ExpectedClusters: expected,
+		})
+	}
+}
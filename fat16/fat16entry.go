@@ -1,6 +1,7 @@
 package fat16
 
 import (
+	"encoding/binary"
 	"io/fs"
 	"strings"
 	"time"
@@ -10,24 +11,126 @@ type directoryEntry struct {
 	Filename          [8]byte
 	FilenameExtension [3]byte
 	FileAttributes    byte
-	_                 [10]byte // Reserved
-	Timecreated       [2]byte
-	Datecreated       [2]byte
+	_                 byte // Reserved
+	CreationTimeFine  byte // 10 ms units
+	CreationTime      uint16
+	CreationDate      uint16
+	AccessDate        uint16
+	StartingclusterHi uint16
+	ModificationTime  uint16
+	ModificationDate  uint16
 	Startingcluster   uint16
 	FileSize          uint32
 }
 
+// parseDirectoryEntry decodes a 32 byte directory slot.
+func parseDirectoryEntry(data []byte) directoryEntry {
+	de := directoryEntry{
+		FileAttributes:    data[11],
+		CreationTimeFine:  data[13],
+		CreationTime:      binary.LittleEndian.Uint16(data[14:]),
+		CreationDate:      binary.LittleEndian.Uint16(data[16:]),
+		AccessDate:        binary.LittleEndian.Uint16(data[18:]),
+		StartingclusterHi: binary.LittleEndian.Uint16(data[20:]),
+		ModificationTime:  binary.LittleEndian.Uint16(data[22:]),
+		ModificationDate:  binary.LittleEndian.Uint16(data[24:]),
+		Startingcluster:   binary.LittleEndian.Uint16(data[26:]),
+		FileSize:          binary.LittleEndian.Uint32(data[28:]),
+	}
+	copy(de.Filename[:], data[:8])
+	copy(de.FilenameExtension[:], data[8:11])
+	return de
+}
+
 func formatFilename(de *directoryEntry) string {
-	filename := strings.TrimSpace(string(de.Filename[:]))
+	name := de.Filename
+	if name[0] == 0x05 { // 0xE5 as first character
+		name[0] = 0xE5
+	}
+	filename := strings.TrimSpace(string(name[:]))
 	if de.FilenameExtension[0] != 0x20 {
 		filename = filename + "." + strings.TrimSpace(string(de.FilenameExtension[:]))
 	}
 	return filename
 }
 
+// dosTime decodes a DOS date and time in the given location. The fine
+// resolution is given in 10 ms units. Invalid dates decode to the zero time.
+func dosTime(date, t uint16, fine byte, loc *time.Location) time.Time {
+	year := 1980 + int(date>>9)
+	month := time.Month(date >> 5 & 0x0F)
+	day := int(date & 0x1F)
+	if month < 1 || month > 12 || day < 1 {
+		return time.Time{}
+	}
+
+	hour := int(t >> 11)
+	min := int(t >> 5 & 0x3F)
+	sec := int(t&0x1F)*2 + int(fine)/100
+	nsec := int(fine) % 100 * int(10*time.Millisecond)
+	return time.Date(year, month, day, hour, min, sec, nsec, loc)
+}
+
+// EntryInfo is returned by Sys and describes a directory entry.
+type EntryInfo struct {
+	Attributes   byte
+	FirstCluster uint32
+	// Deleted is set for entries that were marked as deleted and are only
+	// listed if Options.IncludeDeleted is set. The first character of the
+	// short name of deleted entries is lost and replaced by "_".
+	Deleted bool
+	// Reallocated is set for deleted entries whose clusters are allocated
+	// again, so the content most likely belongs to another file.
+	Reallocated bool
+
+	// ShortName is the 8.3 name of the entry, it can be used as an alternate
+	// path if it differs from the long name.
+	ShortName string
+	// OrphanLongNames contains fragments of long file names that precede
+	// the entry but do not match its checksum or have an invalid sequence.
+	OrphanLongNames []string
+
+	// The timestamps are decoded in Options.Location. Created has a
+	// resolution of 10 ms, Modified of 2 s and Accessed is only a date.
+	// Timestamps that are not set are zero.
+	Created  time.Time
+	Modified time.Time
+	Accessed time.Time
+}
+
 type namedEntry struct {
 	directoryEntry
-	name string
+	name        string
+	shortName   string
+	orphans     []string
+	cluster     uint32
+	deleted     bool
+	reallocated bool
+	location    *time.Location
+}
+
+// contentSize returns the number of bytes that are read for the entry.
+// Directories have no size, so a single cluster is assumed for deleted
+// directories.
+func (d *namedEntry) contentSize(clusterSize int64) int64 {
+	if d.IsDir() {
+		return clusterSize
+	}
+	return int64(d.FileSize)
+}
+
+func (d *namedEntry) info() *EntryInfo {
+	return &EntryInfo{
+		Attributes:      d.FileAttributes,
+		FirstCluster:    d.cluster,
+		Deleted:         d.deleted,
+		Reallocated:     d.reallocated,
+		ShortName:       d.shortName,
+		OrphanLongNames: d.orphans,
+		Created:         dosTime(d.CreationDate, d.CreationTime, d.CreationTimeFine, d.location),
+		Modified:        d.ModTime(),
+		Accessed:        dosTime(d.AccessDate, 0, 0, d.location),
+	}
 }
 
 func (d *namedEntry) Name() string {
@@ -50,7 +153,7 @@ func (d *namedEntry) Mode() fs.FileMode {
 }
 
 func (d *namedEntry) ModTime() time.Time {
-	return time.Time{} // TODO parse d.Timecreated
+	return dosTime(d.ModificationDate, d.ModificationTime, 0, d.location)
 }
 
 func (d *namedEntry) Type() fs.FileMode {
@@ -62,5 +165,5 @@ func (d *namedEntry) Info() (fs.FileInfo, error) {
 }
 
 func (d *namedEntry) Sys() interface{} {
-	return d
+	return d.info()
 }
@@ -20,50 +20,148 @@
 //
 // Author(s): Jonas Plum
 
-// Package fat16 provides an io/fs implementation of the FAT16 file systems.
+// Package fat16 provides an io/fs implementation of the FAT file systems.
+// Despite its name, the package supports FAT12, FAT16 and FAT32. The variant
+// is detected from the BIOS parameter block of the volume.
 package fat16
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
-	"os"
+	"sync"
+	"time"
 
 	"github.com/forensicanalysis/fslib/fsio"
 )
 
-// FS implements a read-only file system for the FAT16 file system.
+// FS implements a read-only file system for the FAT12, FAT16 and FAT32 file
+// systems. All reads use ReadAt on the decoder, so an FS can be used by
+// multiple goroutines if the decoder's ReadAt is safe for concurrent use.
 type FS struct {
-	vh      volumeHeader
+	bpb     biosParameterBlock
+	ebr     extendedBootRecord
+	fsInfo  *fsInfo
+	layout  *layout
 	decoder fsio.ReadSeekerAt
-	fat     []uint16
+	fat     []uint32
+	options Options
+
+	dirCacheMu sync.Mutex
+	dirCache   map[dirKey]*directory
+}
+
+// Options configure the parsing of a FAT file system.
+type Options struct {
+	// IncludeDeleted lists deleted directory entries next to the live
+	// entries. Their content is read from the original starting cluster
+	// assuming contiguous allocation. Deleted items are flagged in Sys.
+	IncludeDeleted bool
+
+	// Location is used to decode timestamps, as FAT stores the local time
+	// of the system that wrote the file system. UTC is used if unset.
+	Location *time.Location
 }
 
 // New creates a new fat16 FS.
 func New(decoder fsio.ReadSeekerAt) (*FS, error) {
-	vh := volumeHeader{}
-	err := binary.Read(decoder, binary.LittleEndian, &vh)
+	return NewWithOptions(decoder, Options{})
+}
+
+// NewWithOptions creates a new fat16 FS with the given options.
+func NewWithOptions(decoder fsio.ReadSeekerAt, options Options) (*FS, error) {
+	bootSector := make([]byte, 512)
+	_, err := decoder.ReadAt(bootSector, 0)
 	if err != nil && err != io.EOF {
 		return nil, err
 	}
 
-	_, err = decoder.Seek(fatOffset(vh), os.SEEK_SET)
+	m := &FS{decoder: decoder, options: options, dirCache: map[dirKey]*directory{}}
+	err = m.parseBootSector(bootSector)
 	if err != nil {
 		return nil, err
 	}
-	fatData := make([]uint16, (vh.SectorsPerFat*vh.SectorSize)/2)
-	err = binary.Read(decoder, binary.LittleEndian, fatData)
+
+	err = m.readFAT()
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = decoder.Seek(0, os.SEEK_SET)
+	return m, nil
+}
+
+func (m *FS) location() *time.Location {
+	if m.options.Location == nil {
+		return time.UTC
+	}
+	return m.options.Location
+}
+
+// Type returns the detected FAT variant.
+func (m *FS) Type() Type { return m.layout.fatType }
+
+func (m *FS) parseBootSector(bootSector []byte) error {
+	vh := volumeHeader{}
+	err := binary.Read(bytes.NewReader(bootSector), binary.LittleEndian, &vh)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	vh32 := fat32VolumeHeader{}
+	err = binary.Read(bytes.NewReader(bootSector), binary.LittleEndian, &vh32)
+	if err != nil {
+		return err
+	}
+
+	sectorsPerFat := uint32(vh.SectorsPerFat)
+	if sectorsPerFat == 0 {
+		sectorsPerFat = vh32.SectorsPerFat32
 	}
 
-	return &FS{vh: vh, decoder: decoder, fat: fatData}, err
+	m.layout, err = newLayout(&vh.biosParameterBlock, sectorsPerFat, vh32.RootCluster)
+	if err != nil {
+		return err
+	}
+
+	m.bpb = vh.biosParameterBlock
+	m.ebr = vh.extendedBootRecord
+	if m.layout.fatType != FAT32 {
+		return nil
+	}
+
+	if vh.SectorsPerFat != 0 || vh.RootdirEntryCount != 0 {
+		return fmt.Errorf("invalid FAT32 boot sector")
+	}
+	m.ebr = vh32.extendedBootRecord
+
+	// only a single FAT is active if mirroring is disabled
+	if vh32.ExtFlags&0x80 != 0 {
+		active := int64(vh32.ExtFlags & 0x0F)
+		if active < m.layout.fatCount {
+			m.layout.fatOffset += active * m.layout.fatSize
+		}
+	}
+
+	if vh32.FsInfoSector != 0 && vh32.FsInfoSector != 0xFFFF {
+		info := &fsInfo{}
+		r := io.NewSectionReader(m.decoder, int64(vh32.FsInfoSector)*int64(vh.SectorSize), 512)
+		if err := binary.Read(r, binary.LittleEndian, info); err == nil && info.valid() {
+			m.fsInfo = info
+		}
+	}
+	return nil
+}
+
+func (m *FS) readFAT() error {
+	fatData := make([]byte, m.layout.fatSize)
+	_, err := m.decoder.ReadAt(fatData, m.layout.fatOffset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	m.fat = decodeFAT(m.layout.fatType, fatData, m.layout.clusterCount+2)
+	return nil
 }
 
 // Open opens a file for reading.
@@ -72,25 +170,34 @@ func (m *FS) Open(name string) (f fs.File, err error) {
 		return nil, fmt.Errorf("path %s invalid", name)
 	}
 
+	root := m.root()
 	if name == "." {
-		var root [8]byte
-		copy(root[:], ".")
-		return NewItem(name, m, &directoryEntry{
-			Filename:          root,
-			FilenameExtension: [3]byte{},
-			FileAttributes:    0x10,
-			Timecreated:       [2]byte{},
-			Datecreated:       [2]byte{},
-			Startingcluster:   2,
-			FileSize:          uint32(m.vh.RootdirEntryCount) * 32,
-		}), nil
-	}
-
-	name, de, err := m.getDirectoryEntry(rootOffset(m.vh), m.vh.RootdirEntryCount, name)
-	// name, de, err := m.getDirectoryEntry(2, m.vh.RootdirEntryCount, name)
+		return m.newItem(name, root), nil
+	}
+
+	de, err := m.getDirectoryEntry(root, name)
 	if err != nil {
+		if name == UnallocatedName && errors.Is(err, fs.ErrNotExist) {
+			return m.Unallocated(), nil
+		}
 		return nil, err
 	}
 
-	return NewItem(name, m, &de.directoryEntry), nil
+	return m.newItem(de.name, de), nil
+}
+
+// root returns an entry for the root directory.
+func (m *FS) root() *namedEntry {
+	var root [8]byte
+	copy(root[:], ".")
+	return &namedEntry{
+		directoryEntry: directoryEntry{
+			Filename:       root,
+			FileAttributes: 0x10,
+			FileSize:       uint32(m.layout.rootEntryCount) * 32,
+		},
+		name:      ".",
+		shortName: ".",
+		location:  m.location(),
+	}
 }
@@ -31,32 +31,33 @@ import (
 	"github.com/forensicanalysis/fslib"
 )
 
-// Item describes files and directories in the FAT16 file system.
+// Item describes files and directories in the FAT file system.
 type Item struct {
 	*io.SectionReader
-	name           string
-	fs             *FS
-	directoryEntry *directoryEntry
+	name  string
+	fs    *FS
+	entry *namedEntry
 
 	dirOffset int
 }
 
 // NewItem creates a new fat16 Item.
 func NewItem(name string, fs *FS, directoryEntry *directoryEntry) *Item {
-	cluster := int64(directoryEntry.Startingcluster)
-
-	pos := getOffset(cluster, fs.vh)
-
-	size := int64(directoryEntry.FileSize)
-	if size == 0 {
-		size = int64(fs.vh.SectorSize) * int64(fs.vh.SectorsPerCluster)
-	}
+	return fs.newItem(name, &namedEntry{
+		directoryEntry: *directoryEntry,
+		name:           name,
+		shortName:      formatFilename(directoryEntry),
+		cluster:        fs.startCluster(directoryEntry),
+		location:       fs.location(),
+	})
+}
 
+func (m *FS) newItem(name string, entry *namedEntry) *Item {
 	return &Item{
-		name:           name,
-		fs:             fs,
-		directoryEntry: directoryEntry,
-		SectionReader:  io.NewSectionReader(fs.decoder, pos, size),
+		name:          name,
+		fs:            m,
+		entry:         entry,
+		SectionReader: m.entryReader(entry),
 	}
 }
 
@@ -70,21 +71,7 @@ func (i *Item) ReadDir(n int) ([]fs.DirEntry, error) {
 		return nil, errors.New("cannot call Readdirnames on a file")
 	}
 
-	size := int64(i.directoryEntry.FileSize)
-	if size == 0 {
-		size = int64(i.fs.vh.SectorSize) * int64(i.fs.vh.SectorsPerCluster)
-	}
-	size /= 32
-
-	var offset int64
-	if i.name == "." {
-		offset = rootOffset(i.fs.vh)
-		size = int64(i.fs.vh.RootdirEntryCount)
-	} else {
-		offset = getOffset(int64(i.directoryEntry.Startingcluster), i.fs.vh)
-	}
-
-	entries, err := i.fs.getDirectoryEntries(offset, uint16(size))
+	entries, err := i.fs.getDirectoryEntries(i.entry)
 	if err != nil {
 		return nil, err
 	}
@@ -134,20 +121,43 @@ func (i *Item) Stat() (fs.FileInfo, error) { return i, nil }
 // Mode returns the fs.FileMode.
 func (i *Item) Mode() fs.FileMode {
 	var mode fs.FileMode
-	if i.directoryEntry.FileAttributes&0x10 != 0 {
+	if i.entry.FileAttributes&0x10 != 0 {
 		mode |= fs.ModeDir
 	}
 	return mode
 }
 
 // ModTime returns the modification time.
-func (*Item) ModTime() time.Time { return time.Time{} } // TODO
+func (i *Item) ModTime() time.Time { return i.entry.ModTime() }
 
-// Sys returns underlying data source.
-func (i *Item) Sys() interface{} { return i.directoryEntry }
+// Sys returns an *EntryInfo with details of the directory entry.
+func (i *Item) Sys() interface{} { return i.entry.info() }
 
 // IsDir returns if the item is a file.
-func (i *Item) IsDir() bool { return i.directoryEntry.FileAttributes&0x10 != 0 }
+func (i *Item) IsDir() bool { return i.entry.FileAttributes&0x10 != 0 }
 
 // Size returns the item's size.
-func (i *Item) Size() int64 { return int64(i.directoryEntry.FileSize) }
+func (i *Item) Size() int64 { return int64(i.entry.FileSize) }
+
+// Slack returns the file slack, the bytes between the end of the file and the
+// end of its last cluster.
+func (i *Item) Slack() (*io.SectionReader, error) {
+	if i.IsDir() {
+		return nil, errors.New("directories have no file slack")
+	}
+
+	size := i.Size()
+	var r *chainReader
+	switch {
+	case size == 0:
+		r = newChainReader(i.fs.decoder, nil)
+	case i.entry.deleted:
+		r = i.fs.contiguousReader(i.entry.cluster, size)
+	default:
+		r = i.fs.chainReader(i.entry.cluster, size)
+	}
+	if r.Size() < size {
+		return io.NewSectionReader(r, 0, 0), nil
+	}
+	return io.NewSectionReader(r, size, r.Size()-size), nil
+}
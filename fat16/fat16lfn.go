@@ -0,0 +1,175 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package fat16
+
+import (
+	"encoding/binary"
+	"unicode/utf16"
+)
+
+// longName collects the long file name (LFN) entries that precede a short
+// directory entry. The entries are stored in reverse order and carry an
+// ordinal and a checksum of the short name they belong to. Fragments that do
+// not form a valid sequence for the following short entry are orphans.
+type longName struct {
+	chars    []uint16
+	checksum byte
+	next     byte // ordinal of the next expected entry
+	count    int
+}
+
+// add adds a live LFN entry and returns the fragments that became orphans.
+func (l *longName) add(data []byte) []string {
+	ordinal := data[0] & 0x1F
+	checksum := data[13]
+	chars := lfnChars(data)
+
+	if data[0]&0x40 != 0 { // last logical, first physical entry
+		orphans := l.reset()
+		if ordinal == 0 {
+			return append(orphans, decodeLFN(chars))
+		}
+		l.chars, l.checksum, l.next, l.count = chars, checksum, ordinal-1, 1
+		return orphans
+	}
+
+	if l.count == 0 || ordinal == 0 || ordinal != l.next || checksum != l.checksum {
+		return append(l.reset(), decodeLFN(chars))
+	}
+	l.chars = append(chars, l.chars...)
+	l.next--
+	l.count++
+	return nil
+}
+
+// match returns the long name if the collected entries are complete and
+// belong to the given short name.
+func (l *longName) match(shortName []byte) string {
+	if l.count == 0 || l.next != 0 || l.checksum != shortNameChecksum(shortName) {
+		return ""
+	}
+	name := decodeLFN(l.chars)
+	*l = longName{}
+	return name
+}
+
+// addDeleted adds a deleted LFN entry. The ordinal of deleted entries is
+// overwritten, so only the checksums can be compared.
+func (l *longName) addDeleted(data []byte) []string {
+	var orphans []string
+	if l.count > 0 && data[13] != l.checksum {
+		orphans = l.reset()
+	}
+	l.chars = append(lfnChars(data), l.chars...)
+	l.checksum = data[13]
+	l.count++
+	return orphans
+}
+
+// matchDeleted returns the long name and the short name of a deleted entry.
+// The lost first character of the short name is restored from the LFN
+// checksum if possible and replaced by "_" otherwise.
+func (l *longName) matchDeleted(shortName []byte) (string, []byte) {
+	restored := make([]byte, len(shortName))
+	copy(restored, shortName)
+	restored[0] = '_'
+	if l.count == 0 {
+		return "", restored
+	}
+
+	// the checksum is a bijection of the first character
+	for c := 0; c < 256; c++ {
+		restored[0] = byte(c)
+		if shortNameChecksum(restored) == l.checksum {
+			break
+		}
+	}
+	if !validShortNameChar(restored[0]) {
+		restored[0] = '_'
+		return "", restored
+	}
+	name := decodeLFN(l.chars)
+	*l = longName{}
+	return name, restored
+}
+
+// reset discards the collected entries and returns them as orphans.
+func (l *longName) reset() []string {
+	if l.count == 0 {
+		return nil
+	}
+	orphan := decodeLFN(l.chars)
+	*l = longName{}
+	return []string{orphan}
+}
+
+// shortNameChecksum calculates the checksum of an 11 byte short name that is
+// stored in its LFN entries.
+func shortNameChecksum(name []byte) byte {
+	var sum byte
+	for _, c := range name {
+		sum = (sum&1)<<7 + sum>>1 + c
+	}
+	return sum
+}
+
+// validShortNameChar returns if c is allowed as first character of a short
+// name.
+func validShortNameChar(c byte) bool {
+	switch {
+	case c <= 0x20, c == 0xE5, c >= 'a' && c <= 'z':
+		return false
+	}
+	for _, invalid := range []byte("\"*+,./:;<=>?[\\]|") {
+		if c == invalid {
+			return false
+		}
+	}
+	return true
+}
+
+// lfnChars returns the 13 UTF-16 characters of an LFN entry.
+func lfnChars(data []byte) []uint16 {
+	chars := make([]uint16, 0, 13)
+	for _, r := range [][2]int{{1, 11}, {14, 26}, {28, 32}} {
+		for i := r[0]; i < r[1]; i += 2 {
+			chars = append(chars, binary.LittleEndian.Uint16(data[i:]))
+		}
+	}
+	return chars
+}
+
+// decodeLFN converts LFN characters to a string. The name ends at the first
+// NUL character, unused characters are padded with 0xFFFF.
+func decodeLFN(chars []uint16) string {
+	var name []uint16
+	for _, c := range chars {
+		if c == 0x0000 {
+			break
+		}
+		if c != 0xFFFF {
+			name = append(name, c)
+		}
+	}
+	return string(utf16.Decode(name))
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package fat16
+
+import (
+	"io"
+	"io/fs"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// UnallocatedName is the name of the virtual file in the root directory that
+// contains all clusters marked as free in the FAT. The file is not listed by
+// ReadDir. Directory entries with the same name take precedence over the
+// virtual file.
+const UnallocatedName = "$Unallocated"
+
+// Unallocated provides the free clusters of the volume as a single file.
+type Unallocated struct {
+	*io.SectionReader
+	fs     *FS
+	chain  *chainReader
+	starts []uint32 // first cluster of each extent of chain
+}
+
+// Unallocated returns the free clusters of the volume as a single file.
+func (m *FS) Unallocated() *Unallocated {
+	clusterSize := m.layout.clusterSize
+	var extents []extent
+	var starts []uint32
+	for c := uint32(2); c < m.layout.clusterCount+2 && int(c) < len(m.fat); c++ {
+		if m.fat[c] != 0 {
+			continue
+		}
+		offset := m.layout.clusterOffset(c)
+		if len(extents) > 0 {
+			last := &extents[len(extents)-1]
+			if last.offset+last.length == offset {
+				last.length += clusterSize
+				continue
+			}
+		}
+		extents = append(extents, extent{offset: offset, length: clusterSize})
+		starts = append(starts, c)
+	}
+
+	chain := newChainReader(m.decoder, extents)
+	return &Unallocated{
+		SectionReader: io.NewSectionReader(chain, 0, chain.Size()),
+		fs:            m,
+		chain:         chain,
+		starts:        starts,
+	}
+}
+
+// Cluster returns the cluster that contains the byte at offset off of the
+// unallocated file and the offset of this byte in the cluster.
+func (u *Unallocated) Cluster(off int64) (cluster uint32, clusterOffset int64, ok bool) {
+	if off < 0 || off >= u.chain.Size() {
+		return 0, 0, false
+	}
+	extents := u.chain.extents
+	idx := sort.Search(len(extents), func(i int) bool {
+		return extents[i].start+extents[i].length > off
+	})
+	rel := off - extents[idx].start
+	clusterSize := u.fs.layout.clusterSize
+	return u.starts[idx] + uint32(rel/clusterSize), rel % clusterSize, true
+}
+
+// VolumeOffset returns the offset on the volume of the byte at offset off of
+// the unallocated file.
+func (u *Unallocated) VolumeOffset(off int64) (int64, bool) {
+	cluster, clusterOffset, ok := u.Cluster(off)
+	if !ok {
+		return 0, false
+	}
+	return u.fs.layout.clusterOffset(cluster) + clusterOffset, true
+}
+
+// Name returns the name of the file.
+func (*Unallocated) Name() string { return UnallocatedName }
+
+// ReadDir fails as the file is not a directory.
+func (*Unallocated) ReadDir(int) ([]fs.DirEntry, error) { return nil, syscall.ENOTDIR }
+
+// Close closes the file.
+func (*Unallocated) Close() error { return nil }
+
+// Stat return an fs.FileInfo object that describes the file.
+func (u *Unallocated) Stat() (fs.FileInfo, error) { return u, nil }
+
+// Mode returns the fs.FileMode.
+func (*Unallocated) Mode() fs.FileMode { return 0 }
+
+// ModTime returns the zero time.
+func (*Unallocated) ModTime() time.Time { return time.Time{} }
+
+// Sys returns nil.
+func (*Unallocated) Sys() interface{} { return nil }
+
+// IsDir returns false.
+func (*Unallocated) IsDir() bool { return false }
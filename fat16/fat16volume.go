@@ -0,0 +1,114 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package fat16
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+// VolumeInfo describes a FAT volume.
+type VolumeInfo struct {
+	Type    Type
+	OEMName string
+	// Label is the volume label of the boot sector, RootLabel the label
+	// stored in the root directory. Windows only updates the latter, so
+	// both can differ.
+	Label        string
+	RootLabel    string
+	SerialNumber uint32
+
+	SectorSize    int64
+	SectorCount   int64
+	ClusterSize   int64
+	FATCount      int
+	TotalClusters uint32
+	// FreeClusters is counted from the FAT, FSInfoFreeClusters is the value
+	// stored in the FAT32 FSInfo sector or -1 if it is not available.
+	FreeClusters       uint32
+	FSInfoFreeClusters int64
+}
+
+// VolumeInfo returns information about the volume.
+func (m *FS) VolumeInfo() (*VolumeInfo, error) {
+	info := &VolumeInfo{
+		Type:               m.layout.fatType,
+		OEMName:            strings.TrimRight(string(m.bpb.CreatingSystemID[:]), " \x00"),
+		SectorSize:         int64(m.bpb.SectorSize),
+		SectorCount:        int64(m.bpb.SectorCountSmall),
+		ClusterSize:        m.layout.clusterSize,
+		FATCount:           int(m.layout.fatCount),
+		TotalClusters:      m.layout.clusterCount,
+		FSInfoFreeClusters: -1,
+	}
+	if info.SectorCount == 0 {
+		info.SectorCount = int64(m.bpb.SectorCountLarge)
+	}
+
+	// the serial number was added with signature 0x28, the label with 0x29
+	switch m.ebr.ExtendedBootSignature {
+	case 0x29:
+		info.Label = formatLabel(m.ebr.VolumeLabel[:])
+		fallthrough
+	case 0x28:
+		info.SerialNumber = binary.LittleEndian.Uint32(m.ebr.VolumeID[:])
+	}
+
+	for c := uint32(2); c < m.layout.clusterCount+2 && int(c) < len(m.fat); c++ {
+		if m.fat[c] == 0 {
+			info.FreeClusters++
+		}
+	}
+	if m.fsInfo != nil && m.fsInfo.FreeCount <= m.layout.clusterCount {
+		info.FSInfoFreeClusters = int64(m.fsInfo.FreeCount)
+	}
+
+	var err error
+	info.RootLabel, err = m.rootLabel()
+	return info, err
+}
+
+// rootLabel returns the volume label entry of the root directory.
+func (m *FS) rootLabel() (string, error) {
+	r := m.directoryReader(0)
+	data := make([]byte, 32)
+	for i := int64(0); i < r.Size()/32; i++ {
+		_, err := io.ReadFull(r, data)
+		if err != nil {
+			return "", err
+		}
+		if data[0] == 0x00 {
+			break
+		}
+		attributes := data[11]
+		if data[0] != 0xE5 && attributes != 0x0F && attributes&0x08 != 0 {
+			return formatLabel(data[:11]), nil
+		}
+	}
+	return "", nil
+}
+
+func formatLabel(label []byte) string {
+	return strings.TrimRight(string(label), " \x00")
+}
@@ -4,7 +4,7 @@ go 1.12
 
 require (
 	github.com/djherbis/times v1.5.0
-	github.com/hashicorp/golang-lru v0.5.4 // indirect
+	github.com/hashicorp/golang-lru v0.5.4
 	github.com/sebdah/goldie v0.0.0-20190531093107-d313ffb52c77 // indirect
 	github.com/stretchr/testify v1.6.0
 	golang.org/x/sys v0.0.0-20220422013727-9388b58f7150
@@ -0,0 +1,68 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+// Package ntfsutil contains helpers for on-disk structures that are shared
+// by the ntfs packages.
+package ntfsutil
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const sectorSize = 512
+
+// Fixup verifies the update sequence array of a multi sector record, like an
+// MFT record, an index record or a $LogFile page, and restores the last two
+// bytes of each sector. The offset and the number of entries of the array
+// are stored at offset 4 of the record.
+func Fixup(record []byte) error {
+	if len(record) < 8 {
+		return errors.New("record too short")
+	}
+	offset := int(binary.LittleEndian.Uint16(record[4:]))
+	count := int(binary.LittleEndian.Uint16(record[6:]))
+	if count == 0 || offset+count*2 > len(record) || (count-1)*sectorSize > len(record) {
+		return errors.New("update sequence array out of bounds")
+	}
+	usa := record[offset : offset+count*2]
+	for i := 1; i < count; i++ {
+		end := i * sectorSize
+		if record[end-2] != usa[0] || record[end-1] != usa[1] {
+			return fmt.Errorf("update sequence mismatch in sector %d", i-1)
+		}
+		copy(record[end-2:end], usa[i*2:i*2+2])
+	}
+	return nil
+}
+
+// Filetime converts a Windows FILETIME, the number of 100 ns intervals since
+// 1601-01-01, to a time.Time. The zero FILETIME is the zero time.Time.
+func Filetime(ft uint64) time.Time {
+	if ft == 0 {
+		return time.Time{}
+	}
+	const epochDiff = 11644473600 // seconds between 1601-01-01 and 1970-01-01
+	return time.Unix(int64(ft/1e7)-epochDiff, int64(ft%1e7)*100).UTC()
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package ntfsutil
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestFixup(t *testing.T) {
+	record := make([]byte, 1024)
+	copy(record[4:], []byte{40, 0, 3, 0})
+	copy(record[40:], []byte{0x01, 0x00, 0xaa, 0xbb, 0xcc, 0xdd})
+	copy(record[510:], []byte{0x01, 0x00})
+	copy(record[1022:], []byte{0x01, 0x00})
+
+	if err := Fixup(record); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(record[510:512], []byte{0xaa, 0xbb}) || !bytes.Equal(record[1022:], []byte{0xcc, 0xdd}) {
+		t.Errorf("Fixup() = %x, %x", record[510:512], record[1022:])
+	}
+
+	// torn write
+	copy(record[1022:], []byte{0x02, 0x00})
+	if err := Fixup(record); err == nil {
+		t.Error("Fixup() should fail for torn writes")
+	}
+
+	if err := Fixup(record[:4]); err == nil {
+		t.Error("Fixup() should fail for short records")
+	}
+}
+
+func TestFiletime(t *testing.T) {
+	want := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got := Filetime(132223104000000000); !got.Equal(want) {
+		t.Errorf("Filetime() = %v, want %v", got, want)
+	}
+	if got := Filetime(0); !got.IsZero() {
+		t.Errorf("Filetime(0) = %v, want zero time", got)
+	}
+}
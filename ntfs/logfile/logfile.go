@@ -0,0 +1,412 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+// Package logfile parses the NTFS journal $LogFile. It contains the redo and
+// undo information of recent metadata operations, e.g. the creation, renaming
+// or deletion of files, that can be used to reconstruct changes which are not
+// reflected in the MFT anymore.
+//
+// The stream can be opened with the ntfs package:
+//
+//	f, _ := fsys.Open("$LogFile")
+//	log, _ := logfile.New(f.(fsio.ReadSeekerAt))
+//	records, _ := log.Records()
+package logfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"unicode/utf16"
+
+	"github.com/forensicanalysis/fslib/fsio"
+	"github.com/forensicanalysis/fslib/ntfs/internal/ntfsutil"
+)
+
+const (
+	restartMagic = "RSTR"
+	checkMagic   = "CHKD"
+	recordMagic  = "RCRD"
+
+	sectorSize = 512
+	// maxPageSize limits the allocation for pages of damaged restart pages.
+	// Windows uses 4096 byte pages.
+	maxPageSize = 64 * 1024
+
+	// restartAreaClean is set in the restart area if the volume was
+	// unmounted cleanly.
+	restartAreaClean = 0x0002
+)
+
+// Options configures the calculation of the MFT entries that are targeted
+// by log records.
+type Options struct {
+	// ClusterSize of the volume, defaults to 4096.
+	ClusterSize int64
+	// RecordSize is the size of MFT records, defaults to 1024.
+	RecordSize int64
+}
+
+// LogFile is a parsed $LogFile.
+type LogFile struct {
+	Restart *RestartArea
+
+	r       io.ReaderAt
+	size    int64
+	options Options
+}
+
+// RestartArea contains the current state of the journal.
+type RestartArea struct {
+	SystemPageSize     uint32
+	LogPageSize        uint32
+	MajorVersion       int16
+	MinorVersion       int16
+	CurrentLSN         uint64
+	Flags              uint16
+	SequenceNumberBits uint32
+	FileSize           int64
+	Clients            []Client
+
+	dataOffset uint16
+}
+
+// Clean returns if the volume was unmounted cleanly.
+func (a *RestartArea) Clean() bool {
+	return a.Flags&restartAreaClean != 0
+}
+
+// Client is a log client, usually only "NTFS".
+type Client struct {
+	Name             string
+	OldestLSN        uint64
+	ClientRestartLSN uint64
+}
+
+type restartPageHeader struct {
+	Magic             [4]byte
+	UpdateSequenceOff uint16
+	UpdateSequenceLen uint16
+	ChkdskLSN         uint64
+	SystemPageSize    uint32
+	LogPageSize       uint32
+	RestartAreaOffset uint16
+	MinorVersion      int16
+	MajorVersion      int16
+}
+
+type restartAreaHeader struct {
+	CurrentLSN            uint64
+	LogClients            uint16
+	ClientFreeList        uint16
+	ClientInUseList       uint16
+	Flags                 uint16
+	SequenceNumberBits    uint32
+	RestartAreaLength     uint16
+	ClientArrayOffset     uint16
+	FileSize              int64
+	LastLSNDataLength     uint32
+	LogRecordHeaderLength uint16
+	LogPageDataOffset     uint16
+}
+
+type clientRecord struct {
+	OldestLSN        uint64
+	ClientRestartLSN uint64
+	PrevClient       uint16
+	NextClient       uint16
+	SequenceNumber   uint16
+	_                [6]byte
+	ClientNameLength uint32
+	ClientName       [64]uint16
+}
+
+type recordPageHeader struct {
+	Magic             [4]byte
+	UpdateSequenceOff uint16
+	UpdateSequenceLen uint16
+	LastLSN           uint64
+	Flags             uint32
+	PageCount         uint16
+	PagePosition      uint16
+	NextRecordOffset  uint16
+	_                 [6]byte
+	LastEndLSN        uint64
+}
+
+// New parses the restart pages of a $LogFile.
+func New(r fsio.ReadSeekerAt) (*LogFile, error) {
+	return NewWithOptions(r, Options{})
+}
+
+// NewWithOptions parses the restart pages of a $LogFile with the cluster and
+// MFT record size of the volume.
+func NewWithOptions(r fsio.ReadSeekerAt, options Options) (*LogFile, error) {
+	if options.ClusterSize <= 0 {
+		options.ClusterSize = 4096
+	}
+	if options.RecordSize <= 0 {
+		options.RecordSize = 1024
+	}
+
+	size, err := fsio.GetSize(r)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &LogFile{r: r, size: size, options: options}
+
+	// there are two copies of the restart page, the one with the higher
+	// current LSN is used
+	first, err := l.readRestartPage(0)
+	secondOffset := int64(4096)
+	if err == nil {
+		secondOffset = int64(first.SystemPageSize)
+	}
+	second, secondErr := l.readRestartPage(secondOffset)
+	switch {
+	case err == nil && (secondErr != nil || first.CurrentLSN >= second.CurrentLSN):
+		l.Restart = first
+	case secondErr == nil:
+		l.Restart = second
+	default:
+		return nil, fmt.Errorf("no valid restart page: %w", err)
+	}
+	return l, nil
+}
+
+func (l *LogFile) readRestartPage(offset int64) (*RestartArea, error) {
+	header := &restartPageHeader{}
+	if err := binary.Read(io.NewSectionReader(l.r, offset, 32), binary.LittleEndian, header); err != nil {
+		return nil, err
+	}
+	if string(header.Magic[:]) != restartMagic && string(header.Magic[:]) != checkMagic {
+		return nil, errors.New("invalid restart page magic")
+	}
+	if !l.validPageSize(header.SystemPageSize) || !l.validPageSize(header.LogPageSize) {
+		return nil, errors.New("invalid restart page size")
+	}
+
+	page := make([]byte, header.SystemPageSize)
+	if _, err := l.r.ReadAt(page, offset); err != nil {
+		return nil, err
+	}
+	if err := ntfsutil.Fixup(page); err != nil {
+		return nil, err
+	}
+
+	areaOffset := int(header.RestartAreaOffset)
+	areaHeader := &restartAreaHeader{}
+	if areaOffset+binary.Size(areaHeader) > len(page) {
+		return nil, errors.New("restart area out of bounds")
+	}
+	if err := binary.Read(bytes.NewReader(page[areaOffset:]), binary.LittleEndian, areaHeader); err != nil {
+		return nil, err
+	}
+	if areaHeader.SequenceNumberBits < 3 || areaHeader.SequenceNumberBits > 64 {
+		return nil, errors.New("invalid sequence number bits")
+	}
+
+	area := &RestartArea{
+		SystemPageSize:     header.SystemPageSize,
+		LogPageSize:        header.LogPageSize,
+		MajorVersion:       header.MajorVersion,
+		MinorVersion:       header.MinorVersion,
+		CurrentLSN:         areaHeader.CurrentLSN,
+		Flags:              areaHeader.Flags,
+		SequenceNumberBits: areaHeader.SequenceNumberBits,
+		FileSize:           areaHeader.FileSize,
+		dataOffset:         areaHeader.LogPageDataOffset,
+	}
+
+	clientOffset := areaOffset + int(areaHeader.ClientArrayOffset)
+	for i := 0; i < int(areaHeader.LogClients); i++ {
+		client := &clientRecord{}
+		offset := clientOffset + i*binary.Size(client)
+		if offset+binary.Size(client) > len(page) {
+			break
+		}
+		if err := binary.Read(bytes.NewReader(page[offset:]), binary.LittleEndian, client); err != nil {
+			return nil, err
+		}
+		nameLength := int(client.ClientNameLength / 2)
+		if nameLength > len(client.ClientName) {
+			nameLength = len(client.ClientName)
+		}
+		area.Clients = append(area.Clients, Client{
+			Name:             string(utf16.Decode(client.ClientName[:nameLength])),
+			OldestLSN:        client.OldestLSN,
+			ClientRestartLSN: client.ClientRestartLSN,
+		})
+	}
+	return area, nil
+}
+
+// validPageSize returns if size is a power of two between one sector and
+// maxPageSize that fits into the $LogFile.
+func (l *LogFile) validPageSize(size uint32) bool {
+	return size >= sectorSize && size <= maxPageSize && int64(size) <= l.size && size&(size-1) == 0
+}
+
+// lsnOffset returns the offset in the $LogFile that is encoded in an LSN.
+func (l *LogFile) lsnOffset(lsn uint64) int64 {
+	bits := uint(l.Restart.SequenceNumberBits)
+	return int64((lsn << bits) >> (bits - 3))
+}
+
+// Records parses all log record pages and returns the log records ordered by
+// their LSN. Pages that fail the update sequence check are skipped.
+func (l *LogFile) Records() ([]*Record, error) {
+	pageSize := int64(l.Restart.LogPageSize)
+	records := map[uint64]*Record{}
+
+	// the log starts after the two restart pages, the following pages are
+	// the tail copies and the circular log area
+	logStart := l.size
+	for offset := 2 * int64(l.Restart.SystemPageSize); offset+pageSize <= l.size; offset += pageSize {
+		page, header, err := l.readRecordPage(offset)
+		if err != nil {
+			continue
+		}
+		base := l.lsnOffset(header.LastLSN) &^ (pageSize - 1)
+		if base == offset && offset < logStart {
+			logStart = offset
+		}
+		for _, record := range l.pageRecords(page, header, base) {
+			if _, ok := records[record.LSN]; !ok {
+				records[record.LSN] = record
+			}
+		}
+	}
+
+	// complete records that continue on the following pages
+	var result []*Record
+	for _, record := range records {
+		if record.missing > 0 {
+			if err := l.readContinuation(record, logStart); err != nil {
+				continue
+			}
+		}
+		record.parseClientData(l.options)
+		result = append(result, record)
+	}
+	sort.Sort(byLSN(result))
+	return result, nil
+}
+
+// readRecordPage reads a log record page and applies the update sequence
+// array.
+func (l *LogFile) readRecordPage(offset int64) ([]byte, *recordPageHeader, error) {
+	page := make([]byte, l.Restart.LogPageSize)
+	if _, err := l.r.ReadAt(page, offset); err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	header := &recordPageHeader{}
+	if err := binary.Read(bytes.NewReader(page), binary.LittleEndian, header); err != nil {
+		return nil, nil, err
+	}
+	if string(header.Magic[:]) != recordMagic {
+		return nil, nil, errors.New("invalid record page magic")
+	}
+	if err := ntfsutil.Fixup(page); err != nil {
+		return nil, nil, err
+	}
+	return page, header, nil
+}
+
+// dataOffset returns the offset of the first log record in a record page.
+func (l *LogFile) dataOffset(header *recordPageHeader) int {
+	if l.Restart.dataOffset != 0 {
+		return int(l.Restart.dataOffset)
+	}
+	return (int(header.UpdateSequenceOff) + int(header.UpdateSequenceLen)*2 + 7) &^ 7
+}
+
+// pageRecords returns the log records that start on a page. The records are
+// found by their LSN, which encodes the offset of the record, so the
+// continuation of a record from the previous page is skipped.
+func (l *LogFile) pageRecords(page []byte, header *recordPageHeader, base int64) []*Record {
+	var records []*Record
+	offset := l.dataOffset(header)
+	for offset+recordHeaderSize <= len(page) {
+		lsn := binary.LittleEndian.Uint64(page[offset:])
+		if lsn == 0 || lsn > header.LastLSN || l.lsnOffset(lsn) != base+int64(offset) {
+			offset += 8
+			continue
+		}
+
+		record, err := parseRecordHeader(page[offset:])
+		if err != nil {
+			offset += 8
+			continue
+		}
+		length := recordHeaderSize + int(record.clientDataLength)
+		if offset+length > len(page) {
+			record.data = append(record.data, page[offset+recordHeaderSize:]...)
+			record.missing = length - (len(page) - offset)
+			record.page = base
+			records = append(records, record)
+			break
+		}
+		record.data = append(record.data, page[offset+recordHeaderSize:offset+length]...)
+		records = append(records, record)
+		offset = (offset + length + 7) &^ 7
+	}
+	return records
+}
+
+// readContinuation reads the remaining client data of a record from the
+// following pages. The log area is circular, so the page after the last page
+// is the first page of the log area.
+func (l *LogFile) readContinuation(record *Record, logStart int64) error {
+	pageSize := int64(l.Restart.LogPageSize)
+	offset := record.page
+	for record.missing > 0 {
+		offset += pageSize
+		if offset+pageSize > l.size {
+			offset = logStart
+		}
+		if offset == record.page {
+			return errors.New("log record does not end")
+		}
+		page, header, err := l.readRecordPage(offset)
+		if err != nil {
+			return err
+		}
+		data := page[l.dataOffset(header):]
+		if len(data) > record.missing {
+			data = data[:record.missing]
+		}
+		record.data = append(record.data, data...)
+		record.missing -= len(data)
+	}
+	return nil
+}
+
+type byLSN []*Record
+
+func (r byLSN) Len() int           { return len(r) }
+func (r byLSN) Less(i, j int) bool { return r[i].LSN < r[j].LSN }
+func (r byLSN) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
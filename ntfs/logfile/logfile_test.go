@@ -0,0 +1,97 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package logfile
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/forensicanalysis/fslib/fsio"
+	"github.com/forensicanalysis/fslib/ntfs"
+)
+
+func TestRecords(t *testing.T) {
+	b, err := os.ReadFile("../../testdata/filesystem/ntfs.dd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := ntfs.New(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fsys.Open("$LogFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := New(f.(fsio.ReadSeekerAt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log.Restart.Clients) == 0 || log.Restart.Clients[0].Name != "NTFS" {
+		t.Errorf("Restart.Clients = %+v", log.Restart.Clients)
+	}
+
+	records, err := log.Records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 {
+		t.Fatal("Records() returned no records")
+	}
+
+	initialized := 0
+	for i, record := range records {
+		if i > 0 && records[i-1].LSN >= record.LSN {
+			t.Fatalf("Records() not ordered: %d after %d", record.LSN, records[i-1].LSN)
+		}
+		if record.Redo == InitializeFileRecordSegment && len(record.RedoData) > 4 {
+			initialized++
+			if string(record.RedoData[:4]) != "FILE" || !record.TargetsMFT {
+				t.Errorf("record %d: %s with data %q, targets MFT %v", record.LSN, record.Redo, record.RedoData[:4], record.TargetsMFT)
+			}
+		}
+	}
+	if initialized == 0 {
+		t.Error("Records() returned no InitializeFileRecordSegment operations")
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(bytes.NewReader(make([]byte, 8192))); err == nil {
+		t.Error("New() should fail without restart pages")
+	}
+
+	l := &LogFile{size: 8192}
+	for size, want := range map[uint32]bool{256: false, 512: true, 4096: true, 6144: false, 16384: false, 1 << 31: false} {
+		if got := l.validPageSize(size); got != want {
+			t.Errorf("validPageSize(%d) = %v, want %v", size, got, want)
+		}
+	}
+	l.size = 1 << 30
+	if l.validPageSize(1 << 20) {
+		t.Error("validPageSize() accepted a page size above the maximum")
+	}
+}
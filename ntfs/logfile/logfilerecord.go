@@ -0,0 +1,201 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package logfile
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const recordHeaderSize = 48
+
+// Record types.
+const (
+	RecordTypeClient  = 1
+	RecordTypeRestart = 2
+)
+
+// Operation is a redo or undo operation of a log record.
+type Operation uint16
+
+// Operations of the NTFS log client.
+const (
+	Noop                         Operation = 0x00
+	CompensationLogRecord        Operation = 0x01
+	InitializeFileRecordSegment  Operation = 0x02
+	DeallocateFileRecordSegment  Operation = 0x03
+	WriteEndOfFileRecordSegment  Operation = 0x04
+	CreateAttribute              Operation = 0x05
+	DeleteAttribute              Operation = 0x06
+	UpdateResidentValue          Operation = 0x07
+	UpdateNonresidentValue       Operation = 0x08
+	UpdateMappingPairs           Operation = 0x09
+	DeleteDirtyClusters          Operation = 0x0A
+	SetNewAttributeSizes         Operation = 0x0B
+	AddIndexEntryRoot            Operation = 0x0C
+	DeleteIndexEntryRoot         Operation = 0x0D
+	AddIndexEntryAllocation      Operation = 0x0E
+	DeleteIndexEntryAllocation   Operation = 0x0F
+	WriteEndOfIndexBuffer        Operation = 0x10
+	SetIndexEntryVcnRoot         Operation = 0x11
+	SetIndexEntryVcnAllocation   Operation = 0x12
+	UpdateFileNameRoot           Operation = 0x13
+	UpdateFileNameAllocation     Operation = 0x14
+	SetBitsInNonresidentBitMap   Operation = 0x15
+	ClearBitsInNonresidentBitMap Operation = 0x16
+	HotFix                       Operation = 0x17
+	EndTopLevelAction            Operation = 0x18
+	PrepareTransaction           Operation = 0x19
+	CommitTransaction            Operation = 0x1A
+	ForgetTransaction            Operation = 0x1B
+	OpenNonresidentAttribute     Operation = 0x1C
+	OpenAttributeTableDump       Operation = 0x1D
+	AttributeNamesDump           Operation = 0x1E
+	DirtyPageTableDump           Operation = 0x1F
+	TransactionTableDump         Operation = 0x20
+	UpdateRecordDataRoot         Operation = 0x21
+	UpdateRecordDataAllocation   Operation = 0x22
+	UpdateRelativeDataInIndex    Operation = 0x23
+	UpdateRelativeDataInIndex2   Operation = 0x24
+	ZeroEndOfFileRecord          Operation = 0x25
+)
+
+var operationNames = []string{
+	"Noop", "CompensationLogRecord", "InitializeFileRecordSegment", "DeallocateFileRecordSegment",
+	"WriteEndOfFileRecordSegment", "CreateAttribute", "DeleteAttribute", "UpdateResidentValue",
+	"UpdateNonresidentValue", "UpdateMappingPairs", "DeleteDirtyClusters", "SetNewAttributeSizes",
+	"AddIndexEntryRoot", "DeleteIndexEntryRoot", "AddIndexEntryAllocation", "DeleteIndexEntryAllocation",
+	"WriteEndOfIndexBuffer", "SetIndexEntryVcnRoot", "SetIndexEntryVcnAllocation", "UpdateFileNameRoot",
+	"UpdateFileNameAllocation", "SetBitsInNonresidentBitMap", "ClearBitsInNonresidentBitMap", "HotFix",
+	"EndTopLevelAction", "PrepareTransaction", "CommitTransaction", "ForgetTransaction",
+	"OpenNonresidentAttribute", "OpenAttributeTableDump", "AttributeNamesDump", "DirtyPageTableDump",
+	"TransactionTableDump", "UpdateRecordDataRoot", "UpdateRecordDataAllocation",
+	"UpdateRelativeDataInIndex", "UpdateRelativeDataInIndex2", "ZeroEndOfFileRecord",
+}
+
+func (o Operation) String() string {
+	if int(o) < len(operationNames) {
+		return operationNames[o]
+	}
+	return fmt.Sprintf("Operation(%#x)", uint16(o))
+}
+
+// targetsFileRecord returns if the operation changes an MFT record. The
+// other operations change index buffers, bitmaps or non-resident data.
+func (o Operation) targetsFileRecord() bool {
+	switch o {
+	case InitializeFileRecordSegment, DeallocateFileRecordSegment, WriteEndOfFileRecordSegment,
+		CreateAttribute, DeleteAttribute, UpdateResidentValue, UpdateMappingPairs,
+		SetNewAttributeSizes, AddIndexEntryRoot, DeleteIndexEntryRoot, SetIndexEntryVcnRoot,
+		UpdateFileNameRoot, UpdateRecordDataRoot, ZeroEndOfFileRecord:
+		return true
+	}
+	return false
+}
+
+// Record is a log record of the NTFS log client.
+type Record struct {
+	LSN           uint64
+	PreviousLSN   uint64
+	UndoNextLSN   uint64
+	Type          uint32
+	TransactionID uint32
+	Flags         uint16
+
+	Redo     Operation
+	Undo     Operation
+	RedoData []byte
+	UndoData []byte
+
+	// TargetAttribute is the index of the changed attribute in the open
+	// attribute table.
+	TargetAttribute    uint16
+	TargetVCN          uint64
+	ClusterBlockOffset uint16
+	RecordOffset       uint16
+	AttributeOffset    uint16
+	LCNs               []uint64
+
+	// MFTEntry is the number of the changed MFT record. It is only valid if
+	// TargetsMFT is set.
+	MFTEntry   uint64
+	TargetsMFT bool
+
+	clientDataLength uint32
+	data             []byte
+	missing          int
+	page             int64
+}
+
+func parseRecordHeader(data []byte) (*Record, error) {
+	record := &Record{
+		LSN:              binary.LittleEndian.Uint64(data),
+		PreviousLSN:      binary.LittleEndian.Uint64(data[8:]),
+		UndoNextLSN:      binary.LittleEndian.Uint64(data[16:]),
+		clientDataLength: binary.LittleEndian.Uint32(data[24:]),
+		Type:             binary.LittleEndian.Uint32(data[32:]),
+		TransactionID:    binary.LittleEndian.Uint32(data[36:]),
+		Flags:            binary.LittleEndian.Uint16(data[40:]),
+	}
+	if record.Type != RecordTypeClient && record.Type != RecordTypeRestart {
+		return nil, errors.New("invalid log record type")
+	}
+	return record, nil
+}
+
+// parseClientData parses the operations of client records.
+func (r *Record) parseClientData(options Options) {
+	if r.Type != RecordTypeClient || len(r.data) < 32 {
+		return
+	}
+	data := r.data
+	r.Redo = Operation(binary.LittleEndian.Uint16(data))
+	r.Undo = Operation(binary.LittleEndian.Uint16(data[2:]))
+	r.RedoData = slice(data, binary.LittleEndian.Uint16(data[4:]), binary.LittleEndian.Uint16(data[6:]))
+	r.UndoData = slice(data, binary.LittleEndian.Uint16(data[8:]), binary.LittleEndian.Uint16(data[10:]))
+	r.TargetAttribute = binary.LittleEndian.Uint16(data[12:])
+	lcnCount := int(binary.LittleEndian.Uint16(data[14:]))
+	r.RecordOffset = binary.LittleEndian.Uint16(data[16:])
+	r.AttributeOffset = binary.LittleEndian.Uint16(data[18:])
+	r.ClusterBlockOffset = binary.LittleEndian.Uint16(data[20:])
+	r.TargetVCN = binary.LittleEndian.Uint64(data[24:])
+	for i := 0; i < lcnCount && 40+i*8 <= len(data); i++ {
+		r.LCNs = append(r.LCNs, binary.LittleEndian.Uint64(data[32+i*8:]))
+	}
+
+	if r.Redo.targetsFileRecord() || r.Undo.targetsFileRecord() {
+		offset := int64(r.TargetVCN)*options.ClusterSize + int64(r.ClusterBlockOffset)*sectorSize
+		r.MFTEntry = uint64(offset / options.RecordSize)
+		r.TargetsMFT = true
+	}
+}
+
+// slice returns length bytes at offset of data or nil if they are out of
+// bounds.
+func slice(data []byte, offset, length uint16) []byte {
+	if length == 0 || int(offset)+int(length) > len(data) {
+		return nil
+	}
+	return data[offset : int(offset)+int(length)]
+}
@@ -23,7 +23,9 @@
 // Package ntfs provides an io/fs implementation of the New Technology File
 // System (NTFS).
 //
-// Currently alternate data streams are not supported.
+// Alternate data streams can be opened with the "file:stream" syntax, e.g.
+// "file.txt:Zone.Identifier". They are not listed by ReadDir, but can be
+// enumerated with Item.Streams.
 package ntfs
 
 import (
@@ -33,7 +35,9 @@ import (
 	"io/fs"
 	"path"
 	"strings"
+	"sync"
 
+	lru "github.com/hashicorp/golang-lru"
 	"www.velocidex.com/golang/go-ntfs/parser"
 )
 
@@ -53,14 +57,36 @@ func checkPageSizeAndCacheSize(pageSize int64, cacheSize int) (int64, int) {
 	return pageSize, cacheSize
 }
 
+// Options configure the parsing of an NTFS file system.
+type Options struct {
+	// PageSize and CacheSize configure the paged reader of the volume.
+	// Defaults are used if unset.
+	PageSize  int64
+	CacheSize int
+
+	// CaseInsensitive resolves paths with the $UpCase table of the volume,
+	// like Windows does. Names of opened files are returned as stored on
+	// disk instead of as passed to Open.
+	CaseInsensitive bool
+
+	// PathCacheSize is the number of directory paths whose MFT references
+	// are cached to speed up the resolution of deep paths.
+	PathCacheSize int
+}
+
 // New creates a new ntfs FS.
 func New(r io.ReaderAt) (fs *FS, err error) {
-	return NewWithSize(r, defaultPageSize, defaultCacheSize)
+	return NewWithOptions(r, Options{})
 }
 
 // NewWithSize creates a new ntfs FS with specific pageSize and cacheSize.
 func NewWithSize(r io.ReaderAt, pageSize int64, cacheSize int) (fs *FS, err error) {
-	pageSize, cacheSize = checkPageSizeAndCacheSize(pageSize, cacheSize)
+	return NewWithOptions(r, Options{PageSize: pageSize, CacheSize: cacheSize})
+}
+
+// NewWithOptions creates a new ntfs FS with the given options.
+func NewWithOptions(r io.ReaderAt, options Options) (fs *FS, err error) {
+	pageSize, cacheSize := checkPageSizeAndCacheSize(options.PageSize, options.CacheSize)
 	defer func() {
 		if r := recover(); r != nil {
 			err = errors.New("error parsing file system as NTFS")
@@ -71,12 +97,42 @@ func NewWithSize(r io.ReaderAt, pageSize int64, cacheSize int) (fs *FS, err erro
 		return nil, err
 	}
 	ntfsCtx, err := parser.GetNTFSContext(reader, 0)
-	return &FS{ntfsCtx: ntfsCtx}, err
+	if err != nil {
+		return nil, err
+	}
+	// the record size is otherwise set on first use, which is not
+	// goroutine-safe
+	ntfsCtx.GetRecordSize()
+
+	if options.PathCacheSize <= 0 {
+		options.PathCacheSize = defaultPathCacheSize
+	}
+	pathCache, err := lru.New(options.PathCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	return &FS{ntfsCtx: ntfsCtx, options: options, pathCache: pathCache}, nil
 }
 
-// FS implements a read-only file system for the NTFS.
+// FS implements a read-only file system for the NTFS. It is safe for
+// concurrent use by multiple goroutines.
 type FS struct {
-	ntfsCtx *parser.NTFSContext
+	ntfsCtx   *parser.NTFSContext
+	options   Options
+	pathCache *lru.Cache
+
+	bitmapOnce sync.Once
+	bitmap     []byte
+	bitmapErr  error
+
+	secureOnce  sync.Once
+	secureIndex map[uint32]int64
+	sds         *streamReader
+	secureErr   error
+
+	upcaseOnce sync.Once
+	upcase     []uint16
+	upcaseErr  error
 }
 
 // Open opens a file for reading.
@@ -85,13 +141,39 @@ func (fsys *FS) Open(name string) (item fs.File, err error) {
 	if !valid || strings.Contains(name, `\`) {
 		return nil, fmt.Errorf("path %s invalid", name)
 	}
+
+	fullName := name
+	name, stream := splitStream(name)
 	name = "/" + name
 
-	dir, err := fsys.ntfsCtx.GetMFT(5)
-	if err != nil {
-		return nil, err
+	entry, resolved, err := fsys.resolve(name)
+	if err != nil && fsys.options.CaseInsensitive && errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", fullName, fs.ErrNotExist)
+	}
+	if err == nil {
+		name = resolved
 	}
-	entry, err := dir.Open(fsys.ntfsCtx, name)
+	if err == nil && stream != "" {
+		attribute, err := dataAttribute(fsys.ntfsCtx, entry, stream)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fullName, fs.ErrNotExist)
+		}
+		stream = attribute.Name()
+	}
+
+	return &Item{entry: entry, name: path.Base(name), stream: stream, path: name, fsys: fsys, ntfsCtx: fsys.ntfsCtx}, err
+}
 
-	return &Item{entry: entry, name: path.Base(name), path: name, ntfsCtx: fsys.ntfsCtx}, err
+// splitStream splits the name of an alternate data stream from a path. The
+// optional attribute type suffix ":$DATA" is removed.
+func splitStream(name string) (string, string) {
+	dir, base := path.Split(name)
+	parts := strings.SplitN(base, ":", 3)
+	if len(parts) == 1 {
+		return name, ""
+	}
+	if len(parts) == 3 && !strings.EqualFold(parts[2], "$DATA") {
+		return name, ""
+	}
+	return dir + parts[0], parts[1]
 }
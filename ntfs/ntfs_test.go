@@ -24,18 +24,25 @@ package ntfs
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"io"
 	"io/fs"
 	"os"
 	"reflect"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"testing/fstest"
 	"testing/iotest"
 	"time"
+	"unicode"
+	"unicode/utf16"
 
 	"github.com/forensicanalysis/fslib/fsio"
 	fslibtest "github.com/forensicanalysis/fslib/fstest"
+	"www.velocidex.com/golang/go-ntfs/parser"
 )
 
 func TestFS(t *testing.T) {
@@ -94,6 +101,853 @@ func Test_NTFSImage(t *testing.T) {
 	fslibtest.RunTest(t, "NTFS", "testdata/filesystem/ntfs.dd", func(f fsio.ReadSeekerAt) (fs.FS, error) { return New(f) }, tests)
 }
 
+func TestStreams(t *testing.T) {
+	b, err := os.ReadFile("../testdata/filesystem/ntfs.dd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := New(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fsys.Open("$Secure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sds *Stream
+	for _, stream := range f.(*Item).Streams() {
+		if stream.Name == "$SDS" {
+			sds = &stream
+		}
+	}
+	if sds == nil {
+		t.Fatal("$SDS stream not found")
+	}
+
+	for _, name := range []string{"$Secure:$SDS", "$secure:$sds", "$Secure:$SDS:$DATA"} {
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.EqualFold(info.Name(), "$Secure:$SDS") || info.Size() != sds.Size || info.IsDir() {
+			t.Errorf("Stat(%s) = %s, %d, %v", name, info.Name(), info.Size(), info.IsDir())
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(data)) != sds.Size {
+			t.Errorf("ReadFile(%s) read %d bytes, want %d", name, len(data), sds.Size)
+		}
+	}
+
+	if _, err := fsys.Open("$Secure:missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open() error = %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestDeleted(t *testing.T) {
+	// a missing NTFS context makes the parser panic like a damaged record
+	if d := (&FS{}).deletedEntry(0, nil); d != nil {
+		t.Errorf("deletedEntry() = %+v, want nil", d)
+	}
+	if _, err := (&FS{}).OpenDeleted(0); err == nil {
+		t.Error("OpenDeleted() of a damaged record should fail")
+	}
+
+	bitmap := []byte{0xff, 0x0f, 0x00, 0x81}
+	for _, tt := range []struct{ lcn, length, want int64 }{
+		{0, 32, 14}, {4, 8, 8}, {10, 3, 2}, {31, 1 << 62, 1}, {32, 10, 0}, {-1, 10, 0}, {0, -5, 0},
+	} {
+		if got := allocatedInRun(bitmap, tt.lcn, tt.length); got != tt.want {
+			t.Errorf("allocatedInRun(%d, %d) = %d, want %d", tt.lcn, tt.length, got, tt.want)
+		}
+	}
+
+	b, err := os.ReadFile("../testdata/filesystem/ntfs.dd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := New(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := fsys.Deleted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range deleted {
+		if entry.Path == "" {
+			t.Errorf("deleted entry %d has no path", entry.Entry)
+		}
+		f, err := fsys.OpenDeleted(entry.Entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.IsDir {
+			continue
+		}
+		data, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(data)) != entry.Size {
+			t.Errorf("deleted entry %d: read %d bytes, want %d", entry.Entry, len(data), entry.Size)
+		}
+	}
+
+	if _, err := fsys.OpenDeleted(5); err == nil {
+		t.Error("OpenDeleted() of the root directory should fail")
+	}
+}
+
+func TestOpenMFT(t *testing.T) {
+	b, err := os.ReadFile("../testdata/filesystem/ntfs.dd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := New(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fsys.OpenMFT(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != "$MFT" {
+		t.Errorf("OpenMFT(0, 1) opened %s", info.Name())
+	}
+
+	if _, err := fsys.OpenMFT(0, 2); err == nil {
+		t.Error("OpenMFT() with wrong sequence number should fail")
+	}
+
+	info, err = fs.Stat(fsys, "folder/subfolder/subfile.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := info.Sys().(*EntryInfo).Entry
+	name, err := fsys.PathOf(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "folder/subfolder/subfile.txt" {
+		t.Errorf("PathOf(%d) = %s", entry, name)
+	}
+
+	name, err = fsys.PathOf(5)
+	if err != nil || name != "." {
+		t.Errorf("PathOf(5) = %s, %v", name, err)
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	b, err := os.ReadFile("../testdata/filesystem/ntfs.dd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := NewWithOptions(bytes.NewReader(b), Options{CaseInsensitive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fsys.Open("FOLDER/SubFolder/SUBFILE.TXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.(*Item).Name() != "subfile.txt" {
+		t.Errorf("Name() = %s, want subfile.txt", f.(*Item).Name())
+	}
+	if _, err := fsys.Open("$mft"); err != nil {
+		t.Error(err)
+	}
+	if table, err := fsys.upcaseTable(); err != nil || len(table) != 0x10000 {
+		t.Errorf("upcaseTable() = %d entries, %v", len(table), err)
+	}
+	if _, err := fsys.Open("folder/missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open() error = %v, want fs.ErrNotExist", err)
+	}
+
+	upcase := make([]uint16, 0x10000)
+	for i := range upcase {
+		upcase[i] = uint16(unicode.ToUpper(rune(i)))
+	}
+	if got := upcaseName(upcase, utf16.Encode([]rune("straße"))); string(utf16.Decode(got)) != "STRAßE" {
+		t.Errorf("upcaseName() = %s", string(utf16.Decode(got)))
+	}
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"A", "B", -1},
+		{"AB", "A", 1},
+		{"_", "A", 1},
+		{"ABC", "ABC", 0},
+	}
+	for _, tt := range tests {
+		if got := collateFileNames(utf16.Encode([]rune(tt.a)), utf16.Encode([]rune(tt.b))); got != tt.want {
+			t.Errorf("collateFileNames(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSearchIndexNode(t *testing.T) {
+	name := utf16.Encode([]rune("File.txt"))
+	entryLength := (16 + fileNameSize + len(name)*2 + 7) &^ 7
+	node := make([]byte, 16+entryLength+16)
+	binary.LittleEndian.PutUint32(node, 16)
+	binary.LittleEndian.PutUint32(node[4:], uint32(len(node)))
+	entry := node[16:]
+	binary.LittleEndian.PutUint64(entry, 3<<48|42)
+	binary.LittleEndian.PutUint16(entry[8:], uint16(entryLength))
+	entry[16+64] = byte(len(name))
+	for i, c := range name {
+		binary.LittleEndian.PutUint16(entry[16+fileNameSize+i*2:], c)
+	}
+	last := node[16+entryLength:]
+	binary.LittleEndian.PutUint16(last[8:], 16)
+	binary.LittleEndian.PutUint32(last[12:], indexEntryLast)
+
+	upcase := make([]uint16, 0x10000)
+	for i := range upcase {
+		upcase[i] = uint16(unicode.ToUpper(rune(i)))
+	}
+	reference, sequence, got, subnode, err := searchIndexNode(node, utf16.Encode([]rune("FILE.TXT")), upcase)
+	if err != nil || reference != 42 || sequence != 3 || got != "File.txt" || subnode != -1 {
+		t.Errorf("searchIndexNode() = %d, %d, %s, %d, %v", reference, sequence, got, subnode, err)
+	}
+	if _, _, got, _, err := searchIndexNode(node, utf16.Encode([]rune("MISSING")), upcase); err != nil || got != "" {
+		t.Errorf("searchIndexNode() = %s, %v, want not found", got, err)
+	}
+
+	for size, want := range map[int64]bool{0: false, 256: false, 512: true, 4096: true, 4097: false, 3 << 10: false, 1 << 31: false} {
+		if got := validIndexRecordSize(size); got != want {
+			t.Errorf("validIndexRecordSize(%d) = %v, want %v", size, got, want)
+		}
+	}
+}
+
+func TestConcurrentOpen(t *testing.T) {
+	b, err := os.ReadFile("../testdata/filesystem/ntfs.dd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := NewWithOptions(bytes.NewReader(b), Options{PathCacheSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := fs.ReadFile(fsys, "folder/subfolder/subfile.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fsys.pathCache.Len() != 2 {
+		t.Errorf("pathCache.Len() = %d, want 2", fsys.pathCache.Len())
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for g := 0; g < cap(errs); g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 10; n++ {
+				got, err := fs.ReadFile(fsys, "folder/subfolder/subfile.txt")
+				if err == nil && !bytes.Equal(got, want) {
+					err = errors.New("content differs")
+				}
+				if err == nil {
+					_, err = fs.ReadDir(fsys, "folder")
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestSys(t *testing.T) {
+	b, err := os.ReadFile("../testdata/filesystem/ntfs.dd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := New(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fsys.OpenMFT(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys, ok := info.Sys().(*EntryInfo)
+	if !ok {
+		t.Fatalf("Sys() = %T, want *EntryInfo", info.Sys())
+	}
+	if sys.Entry != 0 || sys.Sequence == 0 || sys.IsDir || sys.Resident {
+		t.Errorf("Sys() = %+v", sys)
+	}
+	if sys.Size != info.Size() || sys.AllocatedSize < sys.Size {
+		t.Errorf("Sys() size = %d, allocated = %d, want %d", sys.Size, sys.AllocatedSize, info.Size())
+	}
+	// $MFT is a hidden system file
+	if sys.Attributes&0x06 != 0x06 {
+		t.Errorf("Sys() attributes = %#x", sys.Attributes)
+	}
+	for name, timestamp := range map[string]time.Time{
+		"Created": sys.Created, "Modified": sys.Modified, "MFTModified": sys.MFTModified, "Accessed": sys.Accessed,
+		"FileNameCreated": sys.FileNameCreated, "FileNameModified": sys.FileNameModified,
+		"FileNameMFTModified": sys.FileNameMFTModified, "FileNameAccessed": sys.FileNameAccessed,
+	} {
+		if timestamp.Year() < 2000 {
+			t.Errorf("Sys() %s = %s", name, timestamp)
+		}
+	}
+	if !info.ModTime().Equal(sys.Modified) {
+		t.Errorf("ModTime() = %s, want %s", info.ModTime(), sys.Modified)
+	}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.(*DirEntry).entryInfo != nil {
+			t.Errorf("%s: entry info loaded before Sys()", info.Name())
+		}
+		sys := info.Sys().(*EntryInfo)
+		name, err := fsys.PathOf(sys.Entry)
+		if err != nil || name != info.Name() {
+			t.Errorf("%s: PathOf(%d) = %s, %v", info.Name(), sys.Entry, name, err)
+		}
+	}
+}
+
+func TestExtents(t *testing.T) {
+	b, err := os.ReadFile("../testdata/filesystem/ntfs.dd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := New(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fsys.Open("README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := f.(*Item)
+	extents, err := item.Extents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var length int64
+	for _, extent := range extents {
+		if extent.Offset != length {
+			t.Errorf("Extents() gap at %d", length)
+		}
+		length += extent.Length
+	}
+	if length != item.Size() {
+		t.Errorf("Extents() cover %d bytes, want %d", length, item.Size())
+	}
+
+	p := make([]byte, 10)
+	if n, err := item.ReadAt(p, 0); n != 10 || err != nil {
+		t.Errorf("ReadAt(0) = %d, %v", n, err)
+	}
+	if n, err := item.ReadAt(p, item.Size()-5); n != 5 || err != io.EOF {
+		t.Errorf("ReadAt(size-5) = %d, %v", n, err)
+	}
+	if n, err := item.ReadAt(p, item.Size()); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt(size) = %d, %v", n, err)
+	}
+}
+
+func TestStreamReader(t *testing.T) {
+	const clusterSize = 512
+	disk := make([]byte, 26*clusterSize)
+	// LZNT1 chunk with a tag byte and 8 literals
+	copy(disk[4*clusterSize:], []byte{0x08, 0xb0, 0x00, 'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h'})
+	for i := 10 * clusterSize; i < len(disk); i++ {
+		disk[i] = 'x'
+	}
+
+	runs := []dataRun{
+		{vcn: 0, lcn: 4, length: 1},
+		{vcn: 1, length: 31, sparse: true},
+		{vcn: 32, lcn: 10, length: 16},
+	}
+	extents := compressedExtents(runs, 16, clusterSize)
+	want := []Extent{
+		{Offset: 0, Length: 16 * clusterSize, LCN: 4, Compressed: true, clusters: []dataRun{{vcn: 0, lcn: 4, length: 1}}},
+		{Offset: 16 * clusterSize, Length: 16 * clusterSize, Sparse: true},
+		{Offset: 32 * clusterSize, Length: 16 * clusterSize, LCN: 10},
+	}
+	if !reflect.DeepEqual(extents, want) {
+		t.Fatalf("compressedExtents() = %+v, want %+v", extents, want)
+	}
+
+	size := int64(48*clusterSize - 100)
+	r := &streamReader{
+		ntfsCtx:     &parser.NTFSContext{DiskReader: bytes.NewReader(disk), ClusterSize: clusterSize},
+		size:        size,
+		initialized: size - 50,
+		extents:     clipExtents(extents, size),
+	}
+
+	data := make([]byte, size+10)
+	n, err := r.ReadAt(data, 0)
+	if int64(n) != size || err != io.EOF {
+		t.Fatalf("ReadAt() = %d, %v", n, err)
+	}
+	expected := make([]byte, size)
+	copy(expected, "abcdefgh")
+	copy(expected[32*clusterSize:size-50], bytes.Repeat([]byte{'x'}, 16*clusterSize))
+	if !bytes.Equal(data[:n], expected) {
+		t.Error("ReadAt() returned wrong data")
+	}
+
+	if n, err := r.ReadAt(data[:10], 32*clusterSize); n != 10 || err != nil || string(data[:10]) != "xxxxxxxxxx" {
+		t.Errorf("ReadAt() = %d, %v, %q", n, err, data[:10])
+	}
+
+	// short read of resident data
+	r = &streamReader{size: 10, initialized: 4, resident: []byte("abcd")}
+	if n, err := r.ReadAt(data[:8], 6); n != 4 || err != io.EOF || !bytes.Equal(data[:4], make([]byte, 4)) {
+		t.Errorf("ReadAt() = %d, %v, %q", n, err, data[:4])
+	}
+	if n, err := r.ReadAt(data[:4], 2); n != 4 || err != nil || string(data[:4]) != "cd\x00\x00" {
+		t.Errorf("ReadAt() = %d, %v, %q", n, err, data[:4])
+	}
+}
+
+func TestSecurity(t *testing.T) {
+	b, err := os.ReadFile("../testdata/filesystem/ntfs.dd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := New(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{".", "$MFT", "README.md"} {
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sys := info.Sys().(*EntryInfo)
+		if !strings.HasPrefix(string(sys.Owner), "S-1-") || !strings.HasPrefix(string(sys.Group), "S-1-") || len(sys.DACL) == 0 {
+			t.Errorf("%s: Owner = %s, Group = %s, DACL = %v", name, sys.Owner, sys.Group, sys.DACL)
+		}
+	}
+}
+
+func TestSecurityDescriptor(t *testing.T) {
+	sid := func(authority byte, subAuthorities ...uint32) []byte {
+		b := []byte{1, byte(len(subAuthorities)), 0, 0, 0, 0, 0, authority}
+		for _, subAuthority := range subAuthorities {
+			b = append(b, byte(subAuthority), byte(subAuthority>>8), byte(subAuthority>>16), byte(subAuthority>>24))
+		}
+		return b
+	}
+
+	system := sid(5, 18)
+	user := sid(5, 21, 1, 2, 3, 1001)
+	everyone := sid(1, 0)
+
+	ace := append([]byte{ACEAccessAllowed, 0x3, byte(8 + len(everyone)), 0}, 0xa9, 0x00, 0x12, 0x00)
+	ace = append(ace, everyone...)
+	acl := append([]byte{2, 0, byte(8 + len(ace)), 0, 1, 0, 0, 0}, ace...)
+
+	descriptor := make([]byte, 20)
+	descriptor[0] = 1
+	binary.LittleEndian.PutUint32(descriptor[4:], 20)
+	binary.LittleEndian.PutUint32(descriptor[8:], uint32(20+len(user)))
+	binary.LittleEndian.PutUint32(descriptor[16:], uint32(20+len(user)+len(system)))
+	descriptor = append(descriptor, user...)
+	descriptor = append(descriptor, system...)
+	descriptor = append(descriptor, acl...)
+
+	got, err := parseSecurityDescriptor(descriptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &SecurityDescriptor{
+		Owner: "S-1-5-21-1-2-3-1001",
+		Group: "S-1-5-18",
+		DACL:  []ACE{{Type: ACEAccessAllowed, Flags: 0x3, Mask: 0x1200a9, SID: "S-1-1-0"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSecurityDescriptor() = %+v, want %+v", got, want)
+	}
+
+	names := map[SID]string{
+		"S-1-5-18":            "Local System",
+		"S-1-1-0":             "Everyone",
+		"S-1-5-21-1-2-3-500":  "Administrator",
+		"S-1-5-21-1-2-3-1001": "",
+	}
+	for sid, name := range names {
+		if sid.Name() != name {
+			t.Errorf("%s.Name() = %s, want %s", sid, sid.Name(), name)
+		}
+	}
+
+	// a damaged $SDS entry length is not allocated
+	header := make([]byte, sdsHeaderSize)
+	binary.LittleEndian.PutUint32(header[4:], 7)
+	binary.LittleEndian.PutUint32(header[16:], 0xfffffff0)
+	fsys := &FS{secureIndex: map[uint32]int64{7: 0}, sds: &streamReader{size: int64(len(header)), initialized: int64(len(header)), resident: header}}
+	fsys.secureOnce.Do(func() {})
+	if _, err := fsys.securityDescriptor(7); err == nil {
+		t.Error("securityDescriptor() accepted a damaged $SDS entry")
+	}
+}
+
+func usnRecord(major uint16, entry, parent uint64, usn int64, reason uint32, name string) []byte {
+	chars := utf16.Encode([]rune(name))
+	header := 60
+	if major == 3 {
+		header = 76
+	}
+	record := make([]byte, (header+len(chars)*2+7)&^7)
+	binary.LittleEndian.PutUint32(record, uint32(len(record)))
+	binary.LittleEndian.PutUint16(record[4:], major)
+	fields := record[32:]
+	binary.LittleEndian.PutUint64(record[8:], entry)
+	if major == 2 {
+		binary.LittleEndian.PutUint64(record[16:], parent)
+		binary.LittleEndian.PutUint64(record[24:], uint64(usn))
+	} else {
+		binary.LittleEndian.PutUint64(record[24:], parent)
+		binary.LittleEndian.PutUint64(record[40:], uint64(usn))
+		fields = record[48:]
+	}
+	binary.LittleEndian.PutUint64(fields, 132223104000000000) // 2020-01-01
+	binary.LittleEndian.PutUint32(fields[8:], reason)
+	binary.LittleEndian.PutUint16(fields[24:], uint16(len(chars)*2))
+	binary.LittleEndian.PutUint16(fields[26:], uint16(header))
+	for i, c := range chars {
+		binary.LittleEndian.PutUint16(record[header+i*2:], c)
+	}
+	return record
+}
+
+func TestUSNJournal(t *testing.T) {
+	b, err := os.ReadFile("../testdata/filesystem/ntfs.dd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := New(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.USNJournal(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal(err)
+	}
+
+	root, err := fsys.ntfsCtx.GetMFT(rootEntry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootRef := uint64(rootEntry) | uint64(root.Sequence_value())<<48
+
+	v4 := make([]byte, 80)
+	binary.LittleEndian.PutUint32(v4, 80)
+	binary.LittleEndian.PutUint16(v4[4:], 4)
+	binary.LittleEndian.PutUint64(v4[8:], 64|1<<48)
+	binary.LittleEndian.PutUint64(v4[40:], 4096+256)
+	binary.LittleEndian.PutUint32(v4[48:], USNReasonDataOverwrite)
+	binary.LittleEndian.PutUint16(v4[60:], 1)
+	binary.LittleEndian.PutUint16(v4[62:], 16)
+	binary.LittleEndian.PutUint64(v4[64:], 512)
+	binary.LittleEndian.PutUint64(v4[72:], 1024)
+
+	// the first page of the journal is sparse
+	journal := make([]byte, 8192)
+	page := journal[4096:]
+	n := copy(page, usnRecord(2, 64|1<<48, rootRef, 4096, USNReasonFileCreate, "file.txt"))
+	n += 16                                    // padding
+	binary.LittleEndian.PutUint32(page[n:], 3) // damaged record
+	n += 8
+	n += copy(page[n:], usnRecord(3, 65|2<<48, 64|7<<48, 4096+int64(n), USNReasonRenameNewName, "moved.txt"))
+	copy(page[256:], v4)
+
+	j := newUSNJournal(fsys, bytes.NewReader(journal), []Extent{{Offset: 4096, Length: 4096}})
+	var records []*USNRecord
+	for {
+		record, err := j.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Next() returned %d records, want 3", len(records))
+	}
+
+	want := &USNRecord{
+		MajorVersion: 2, USN: 4096, Entry: 64, Sequence: 1, ParentEntry: rootEntry, ParentSequence: root.Sequence_value(),
+		Timestamp: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC), Reason: USNReasonFileCreate, Name: "file.txt",
+	}
+	if !reflect.DeepEqual(records[0], want) {
+		t.Errorf("Next() = %+v, want %+v", records[0], want)
+	}
+	if name := j.Path(records[0]); name != "file.txt" {
+		t.Errorf("Path() = %s, want file.txt", name)
+	}
+
+	if records[1].MajorVersion != 3 || records[1].Entry != 65 || records[1].Sequence != 2 || records[1].Name != "moved.txt" {
+		t.Errorf("Next() = %+v", records[1])
+	}
+	if name := j.Path(records[1]); name != "$OrphanFiles/moved.txt" {
+		t.Errorf("Path() = %s, want $OrphanFiles/moved.txt", name)
+	}
+
+	wantExtents := []USNExtent{{Offset: 512, Length: 1024}}
+	if records[2].MajorVersion != 4 || records[2].USN != 4096+256 || !reflect.DeepEqual(records[2].Extents, wantExtents) {
+		t.Errorf("Next() = %+v", records[2])
+	}
+}
+
+func indexEntry(entry, parent uint64, name string) []byte {
+	chars := utf16.Encode([]rune(name))
+	data := make([]byte, (16+fileNameSize+len(chars)*2+7)&^7)
+	binary.LittleEndian.PutUint64(data, entry)
+	binary.LittleEndian.PutUint16(data[8:], uint16(len(data)))
+	binary.LittleEndian.PutUint16(data[10:], uint16(fileNameSize+len(chars)*2))
+	key := data[16:]
+	binary.LittleEndian.PutUint64(key, parent)
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint64(key[8+i*8:], 132223104000000000) // 2020-01-01
+	}
+	binary.LittleEndian.PutUint64(key[40:], 4096)
+	binary.LittleEndian.PutUint64(key[48:], 12)
+	key[64] = byte(len(chars))
+	key[65] = 1
+	for i, c := range chars {
+		binary.LittleEndian.PutUint16(key[fileNameSize+i*2:], c)
+	}
+	return data
+}
+
+func TestIndexSlack(t *testing.T) {
+	rootRef := uint64(5 | 5<<48)
+	record := make([]byte, 4096)
+	n := 64
+	n += copy(record[n:], indexEntry(64|1<<48, rootRef, "live.txt"))
+	used := n + 16 // end of index entry
+	n = used + copy(record[used:], indexEntry(65|3<<48, rootRef, "deleted.txt"))
+	copy(record[n:], indexEntry(66|1<<48, 70|1<<48, "other.txt"))
+
+	entries := carveIndexEntries(record, used, 5)
+	if len(entries) != 1 {
+		t.Fatalf("carveIndexEntries() returned %d entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.Name != "deleted.txt" || entry.Entry != 65 || entry.Sequence != 3 || entry.ParentEntry != 5 {
+		t.Errorf("carveIndexEntries() = %+v", entry)
+	}
+	if !entry.Recovered || entry.Size != 12 || entry.AllocatedSize != 4096 || entry.Offset != int64(used+16) {
+		t.Errorf("carveIndexEntries() = %+v", entry)
+	}
+	if want := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC); !entry.Modified.Equal(want) {
+		t.Errorf("Modified = %v, want %v", entry.Modified, want)
+	}
+
+	if !indexRecordInUse(nil, 3) {
+		t.Error("indexRecordInUse() = false without $BITMAP")
+	}
+	if !indexRecordInUse([]byte{0x02}, 1) || indexRecordInUse([]byte{0x02}, 0) || indexRecordInUse([]byte{0x02}, 8) {
+		t.Error("indexRecordInUse() does not follow $BITMAP")
+	}
+
+	b, err := os.ReadFile("../testdata/filesystem/ntfs.dd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := New(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := fsys.Open(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := root.(*Item).IndexSlack(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func reparseBuffer(tag uint32, flags uint32, target, printName string) []byte {
+	substitute := utf16.Encode([]rune(target))
+	display := utf16.Encode([]rune(printName))
+	header := 8
+	if tag == ReparseTagSymlink {
+		header = 12
+	}
+	data := make([]byte, 8+header+(len(substitute)+len(display))*2)
+	binary.LittleEndian.PutUint32(data, tag)
+	binary.LittleEndian.PutUint16(data[4:], uint16(len(data)-8))
+	binary.LittleEndian.PutUint16(data[10:], uint16(len(substitute)*2))
+	binary.LittleEndian.PutUint16(data[12:], uint16(len(substitute)*2))
+	binary.LittleEndian.PutUint16(data[14:], uint16(len(display)*2))
+	if tag == ReparseTagSymlink {
+		binary.LittleEndian.PutUint32(data[16:], flags)
+	}
+	for i, c := range append(substitute, display...) {
+		binary.LittleEndian.PutUint16(data[8+header+i*2:], c)
+	}
+	return data
+}
+
+func TestReparsePoint(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want ReparsePoint
+		link bool
+	}{
+		{"junction", reparseBuffer(ReparseTagMountPoint, 0, `\??\C:\Users\Public`, `C:\Users\Public`), ReparsePoint{Tag: ReparseTagMountPoint, Target: `C:\Users\Public`, PrintName: `C:\Users\Public`}, true},
+		{"symlink", reparseBuffer(ReparseTagSymlink, 1, `..\file.txt`, `..\file.txt`), ReparsePoint{Tag: ReparseTagSymlink, Target: `..\file.txt`, PrintName: `..\file.txt`, Relative: true}, true},
+		{"wof", []byte{0x17, 0, 0, 0x80, 4, 0, 0, 0, 1, 0, 0, 0}, ReparsePoint{Tag: ReparseTagWOF}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseReparsePoint(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Tag != tt.want.Tag || got.Target != tt.want.Target || got.PrintName != tt.want.PrintName || got.Relative != tt.want.Relative {
+				t.Errorf("parseReparsePoint() = %+v, want %+v", got, tt.want)
+			}
+			if got.IsLink() != tt.link {
+				t.Errorf("IsLink() = %v, want %v", got.IsLink(), tt.link)
+			}
+		})
+	}
+
+	if _, err := parseReparsePoint([]byte{0x03, 0, 0, 0xA0, 0xff, 0, 0, 0}); err == nil {
+		t.Error("parseReparsePoint() accepted truncated data")
+	}
+	if !(&ReparsePoint{Tag: 0x9000101A}).IsCloud() {
+		t.Error("IsCloud() = false for OneDrive placeholder")
+	}
+
+	// without the reparse flag in the index, Mode does not read the entry
+	var attributes uint32
+	entry := &DirEntry{info: &parser.FileInfo{Name: "dir", IsDir: true}, attributes: &attributes}
+	if entry.Mode() != fs.ModeDir || entry.Type() != fs.ModeDir {
+		t.Errorf("Mode() = %s, Type() = %s, want %s", entry.Mode(), entry.Type(), fs.ModeDir)
+	}
+	if entry.entryInfo != nil {
+		t.Error("Mode() loaded the entry info")
+	}
+}
+
+func TestHardLinks(t *testing.T) {
+	b, err := os.ReadFile("../testdata/filesystem/ntfs.dd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := New(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := fsys.HardLinks(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(paths, []string{"$MFT"}) {
+		t.Errorf("HardLinks() = %v, want [$MFT]", paths)
+	}
+	if _, err := fsys.ReadLink("$MFT"); err == nil {
+		t.Error("ReadLink() of a regular file succeeded")
+	}
+}
+
+func TestVolumeInfo(t *testing.T) {
+	b, err := os.ReadFile("../testdata/filesystem/ntfs.dd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := New(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := fsys.VolumeInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.MajorVersion != 3 || info.RecordSize != 1024 || info.BytesPerSector != 512 {
+		t.Errorf("VolumeInfo() = %+v", info)
+	}
+	if info.TotalClusters*info.ClusterSize > int64(len(b)) || info.FreeClusters <= 0 || info.FreeClusters >= info.TotalClusters {
+		t.Errorf("VolumeInfo() = %+v", info)
+	}
+	if info.MFTCluster == 0 || info.MFTMirrCluster == 0 {
+		t.Errorf("VolumeInfo() = %+v", info)
+	}
+	entry, err := fsys.ntfsCtx.GetMFT(volumeEntry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := attributeData(fsys.ntfsCtx, entry, volumeInformationAttributeType, 4); err == nil {
+		t.Error("attributeData() ignored the maximum size")
+	}
+
+	if got := allocatedClusters([]byte{0xff, 0x0f, 0xff}, 12); got != 12 {
+		t.Errorf("allocatedClusters() = %d, want 12", got)
+	}
+	if got := allocatedClusters([]byte{0xff, 0xff}, 20); got != 16 {
+		t.Errorf("allocatedClusters() = %d, want 16", got)
+	}
+	if got := recordSize(-10, 4096); got != 1024 {
+		t.Errorf("recordSize(-10) = %d, want 1024", got)
+	}
+	if got := recordSize(1, 4096); got != 4096 {
+		t.Errorf("recordSize(1) = %d, want 4096", got)
+	}
+}
+
 func TestNew(t *testing.T) {
 	r := bytes.NewReader([]byte{})
 	type args struct {
@@ -0,0 +1,164 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package ntfs
+
+import (
+	"fmt"
+	"math/bits"
+
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+// DeletedEntry describes an MFT record that is not in use anymore.
+type DeletedEntry struct {
+	Entry    uint64
+	Sequence uint16
+	// Path is the best known path of the entry, rebuilt from the parent
+	// references of its $FILE_NAME attributes.
+	Path  string
+	IsDir bool
+	Size  int64
+	// ReallocatedClusters is the number of clusters of the unnamed $DATA
+	// stream that are allocated again. Their content most likely belongs to
+	// another file.
+	ReallocatedClusters int64
+}
+
+// Deleted enumerates the MFT records without the in-use flag. Records that
+// were never used or that are extension records of other entries are
+// skipped.
+func (fsys *FS) Deleted() ([]*DeletedEntry, error) {
+	bitmap, err := fsys.clusterBitmap()
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []*DeletedEntry
+	count := fsys.entryCount()
+	for id := int64(0); id < count; id++ {
+		if d := fsys.deletedEntry(id, bitmap); d != nil {
+			deleted = append(deleted, d)
+		}
+	}
+	return deleted, nil
+}
+
+// deletedEntry parses an MFT record for Deleted. It returns nil for records
+// that are in use, unused or damaged. Unallocated records often contain
+// garbage that makes the parser panic, so panics are recovered.
+func (fsys *FS) deletedEntry(id int64, bitmap []byte) (d *DeletedEntry) {
+	defer func() {
+		if r := recover(); r != nil {
+			d = nil
+		}
+	}()
+
+	entry, err := fsys.ntfsCtx.GetMFT(id)
+	if err != nil {
+		return nil // uninitialized record
+	}
+	if entry.Flags().IsSet("ALLOCATED") || entry.Base_record_reference() != 0 {
+		return nil
+	}
+	if fileName(fsys.ntfsCtx, entry) == nil {
+		return nil
+	}
+
+	d = &DeletedEntry{
+		Entry:    uint64(id),
+		Sequence: entry.Sequence_value(),
+		Path:     fsys.fullPath(entry),
+		IsDir:    entry.Flags().IsSet("DIRECTORY"),
+	}
+	if attribute, err := dataAttribute(fsys.ntfsCtx, entry, ""); err == nil {
+		d.Size = attribute.DataSize()
+		d.ReallocatedClusters = reallocatedClusters(fsys.ntfsCtx, entry, attribute, bitmap)
+	}
+	return d
+}
+
+// OpenDeleted opens a deleted MFT record. The content is read from the
+// remaining runs of its $DATA attributes, also if the clusters are
+// allocated again.
+func (fsys *FS) OpenDeleted(entry uint64) (item *Item, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			item, err = nil, fmt.Errorf("MFT entry %d is damaged", entry)
+		}
+	}()
+
+	mftEntry, err := fsys.ntfsCtx.GetMFT(int64(entry))
+	if err != nil {
+		return nil, err
+	}
+	if mftEntry.Flags().IsSet("ALLOCATED") {
+		return nil, fmt.Errorf("MFT entry %d is in use", entry)
+	}
+	return fsys.newItem(mftEntry), nil
+}
+
+// reallocatedClusters counts the clusters of a non-resident stream that are
+// marked as allocated in $Bitmap.
+func reallocatedClusters(ntfsCtx *parser.NTFSContext, entry *parser.MFT_ENTRY, first *parser.NTFS_ATTRIBUTE, bitmap []byte) int64 {
+	var count int64
+	for _, attribute := range entry.EnumerateAttributes(ntfsCtx) {
+		if attribute.Type().Value != dataAttributeType || attribute.Attribute_id() != first.Attribute_id() || attribute.IsResident() {
+			continue
+		}
+		var lcn int64
+		for _, run := range attribute.RunList() {
+			if run.RelativeUrnOffset == 0 { // sparse
+				continue
+			}
+			lcn += run.RelativeUrnOffset
+			count += allocatedInRun(bitmap, lcn, run.Length)
+		}
+	}
+	return count
+}
+
+// allocatedInRun counts the clusters of a run that are marked as allocated in
+// $Bitmap. Runs of damaged records may be arbitrarily long, so the run is
+// clipped to the bitmap and counted bytewise.
+func allocatedInRun(bitmap []byte, lcn, length int64) int64 {
+	end := int64(len(bitmap)) * 8
+	if lcn < 0 || length <= 0 || lcn >= end {
+		return 0
+	}
+	if length > end-lcn {
+		length = end - lcn
+	}
+
+	var count int64
+	for c := lcn; c < lcn+length; {
+		b := bitmap[c/8] >> uint(c%8)
+		n := 8 - c%8
+		if rest := lcn + length - c; n > rest {
+			n = rest
+			b &= byte(1<<uint(n)) - 1
+		}
+		count += int64(bits.OnesCount8(b))
+		c += n
+	}
+	return count
+}
@@ -2,6 +2,7 @@ package ntfs
 
 import (
 	"io/fs"
+	"sync"
 	"time"
 
 	"www.velocidex.com/golang/go-ntfs/parser"
@@ -9,6 +10,21 @@ import (
 
 type DirEntry struct {
 	info *parser.FileInfo
+	fsys *FS
+	// attributes are the file attribute flags of the $FILE_NAME attribute in
+	// the parent directory's index, nil if the entry was not listed from an
+	// index.
+	attributes *uint32
+
+	reparseOnce sync.Once
+	reparseTag  uint32
+
+	entryInfoOnce sync.Once
+	entryInfo     *EntryInfo
+}
+
+func newDirEntry(fsys *FS, info *parser.FileInfo) *DirEntry {
+	return &DirEntry{info: info, fsys: fsys}
 }
 
 func (d *DirEntry) Name() string {
@@ -16,33 +32,83 @@ func (d *DirEntry) Name() string {
 }
 
 func (d *DirEntry) IsDir() bool {
-	return d.info.IsDir
+	return d.Mode().IsDir()
 }
 
 func (d *DirEntry) Size() int64 {
 	return d.info.Size
 }
 
+// Mode returns fs.ModeSymlink for symbolic links and junctions and
+// fs.ModeIrregular for other reparse points, e.g. cloud file placeholders or
+// WOF compressed files.
 func (d *DirEntry) Mode() fs.FileMode {
-	if d.IsDir() {
-		return fs.ModeDir
+	var mode fs.FileMode
+	if d.info.IsDir {
+		mode = fs.ModeDir
 	}
-	return 0
+	if tag := d.loadReparseTag(); tag != 0 {
+		if isLinkTag(tag) {
+			return fs.ModeSymlink
+		}
+		mode |= fs.ModeIrregular
+	}
+	return mode
 }
 
+// ModTime returns the modification time of the $STANDARD_INFORMATION
+// attribute.
 func (d *DirEntry) ModTime() time.Time {
-	return d.info.Mtime
+	if info := d.Sys().(*EntryInfo); !info.Modified.IsZero() {
+		return info.Modified
+	}
+	return d.info.Mtime.UTC()
 }
 
+// Sys returns the *EntryInfo of the MFT entry.
 func (d *DirEntry) Sys() interface{} {
-	return d.info
+	d.entryInfoOnce.Do(func() {
+		d.entryInfo = d.loadEntryInfo()
+	})
+	return d.entryInfo
 }
 
-func (d *DirEntry) Type() fs.FileMode {
-	if d.IsDir() {
-		return fs.ModeDir
+func (d *DirEntry) loadEntryInfo() *EntryInfo {
+	id, attributeType, attributeID, err := parser.ParseMFTId(d.info.MFTId)
+	if err != nil {
+		return &EntryInfo{IsDir: d.info.IsDir, Size: d.info.Size}
 	}
-	return 0
+	entry, err := d.fsys.ntfsCtx.GetMFT(id)
+	if err != nil {
+		return &EntryInfo{Entry: uint64(id), IsDir: d.info.IsDir, Size: d.info.Size}
+	}
+	return d.fsys.newEntryInfo(entry, entryAttribute(d.fsys.ntfsCtx, entry, attributeType, attributeID))
+}
+
+// loadReparseTag returns the tag of the $REPARSE_POINT attribute. Only that
+// attribute is read and only if the index flags do not rule it out.
+func (d *DirEntry) loadReparseTag() uint32 {
+	d.reparseOnce.Do(func() {
+		if d.attributes != nil && *d.attributes&fileAttributeReparsePoint == 0 {
+			return
+		}
+		id, _, _, err := parser.ParseMFTId(d.info.MFTId)
+		if err != nil {
+			return
+		}
+		entry, err := d.fsys.ntfsCtx.GetMFT(id)
+		if err != nil {
+			return
+		}
+		if reparsePoint, err := entryReparsePoint(d.fsys.ntfsCtx, entry); err == nil {
+			d.reparseTag = reparsePoint.Tag
+		}
+	})
+	return d.reparseTag
+}
+
+func (d *DirEntry) Type() fs.FileMode {
+	return d.Mode().Type()
 }
 
 func (d *DirEntry) Info() (fs.FileInfo, error) {
@@ -0,0 +1,131 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package ntfs
+
+import (
+	"time"
+
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+// EntryInfo contains the metadata of an MFT entry. It is returned by the Sys
+// method of the fs.FileInfo of files and directories.
+type EntryInfo struct {
+	Entry    uint64
+	Sequence uint16
+
+	// Attributes contains the file attribute flags of the
+	// $STANDARD_INFORMATION attribute, e.g. 0x02 for hidden files.
+	Attributes uint32
+	// IsDir is set if the MFT entry is flagged as directory. Entries like
+	// $Secure contain indexes, but are not directories.
+	IsDir bool
+	// ReparseTag is the tag of the $REPARSE_POINT attribute of symbolic
+	// links, junctions and other reparse points, or 0.
+	ReparseTag uint32
+
+	// Resident is set if the content is stored inside the MFT entry.
+	Resident      bool
+	Size          int64
+	AllocatedSize int64
+
+	// Timestamps of the $STANDARD_INFORMATION attribute.
+	Created     time.Time
+	Modified    time.Time
+	MFTModified time.Time
+	Accessed    time.Time
+
+	// Timestamps of the $FILE_NAME attribute. They are only updated by
+	// Windows when the file is created, renamed or moved.
+	FileNameCreated     time.Time
+	FileNameModified    time.Time
+	FileNameMFTModified time.Time
+	FileNameAccessed    time.Time
+
+	// SecurityID references the security descriptor in $Secure, which
+	// contains the owner, the group and the DACL of the entry.
+	SecurityID uint32
+	Owner      SID
+	Group      SID
+	DACL       []ACE
+}
+
+// newEntryInfo collects the metadata of an MFT entry. The sizes are taken from
+// attribute, which is the $DATA attribute of files and the index attribute of
+// directories.
+func (fsys *FS) newEntryInfo(entry *parser.MFT_ENTRY, attribute *parser.NTFS_ATTRIBUTE) *EntryInfo {
+	ntfsCtx := fsys.ntfsCtx
+	info := &EntryInfo{
+		Entry:    uint64(entry.Record_number()),
+		Sequence: entry.Sequence_value(),
+		IsDir:    entry.Flags().IsSet("DIRECTORY"),
+	}
+
+	if si, err := entry.StandardInformation(ntfsCtx); err == nil {
+		info.Attributes = uint32(si.Flags().Value)
+		info.Created = si.Create_time().UTC()
+		info.Modified = si.File_altered_time().UTC()
+		info.MFTModified = si.Mft_altered_time().UTC()
+		info.Accessed = si.File_accessed_time().UTC()
+		info.SecurityID = si.Sid()
+	}
+	if info.Attributes&fileAttributeReparsePoint != 0 {
+		if reparsePoint, err := entryReparsePoint(ntfsCtx, entry); err == nil {
+			info.ReparseTag = reparsePoint.Tag
+		}
+	}
+	if descriptor, err := fsys.entrySecurityDescriptor(entry, info.SecurityID); err == nil {
+		info.Owner = descriptor.Owner
+		info.Group = descriptor.Group
+		info.DACL = descriptor.DACL
+	}
+
+	if name := fileName(ntfsCtx, entry); name != nil {
+		info.FileNameCreated = name.Created().UTC()
+		info.FileNameModified = name.File_modified().UTC()
+		info.FileNameMFTModified = name.Mft_modified().UTC()
+		info.FileNameAccessed = name.File_accessed().UTC()
+	}
+
+	if attribute != nil {
+		info.Resident = attribute.IsResident()
+		info.Size = attribute.DataSize()
+		if info.Resident {
+			info.AllocatedSize = int64(attribute.Content_size())
+		} else {
+			info.AllocatedSize = int64(attribute.Allocated_size())
+		}
+	}
+	return info
+}
+
+// entryAttribute returns the attribute of an MFT entry with the given type
+// and id.
+func entryAttribute(ntfsCtx *parser.NTFSContext, entry *parser.MFT_ENTRY, attributeType, id int64) *parser.NTFS_ATTRIBUTE {
+	for _, attribute := range entry.EnumerateAttributes(ntfsCtx) {
+		if int64(attribute.Type().Value) == attributeType && int64(attribute.Attribute_id()) == id && isFirstVCN(attribute) {
+			return attribute
+		}
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package ntfs
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+// Extent describes a part of a stream. Sparse extents are holes that read as
+// zeros. Compressed extents are LZNT1 compressed compression units, their
+// LCN is the first cluster of the compressed data. Resident streams consist
+// of a single resident extent.
+type Extent struct {
+	Offset     int64
+	Length     int64
+	LCN        int64
+	Sparse     bool
+	Compressed bool
+	Resident   bool
+
+	clusters []dataRun // clusters of compressed units
+}
+
+// dataRun is a run of clusters of a non-resident attribute.
+type dataRun struct {
+	vcn    int64
+	lcn    int64
+	length int64
+	sparse bool
+}
+
+// Extents returns the extents of the item's stream.
+func (i *Item) Extents() ([]Extent, error) {
+	r, err := i.streamReader()
+	if err != nil {
+		return nil, err
+	}
+	return append([]Extent{}, r.extents...), nil
+}
+
+// streamReader reads a stream from its extents. It is safe for concurrent
+// use.
+type streamReader struct {
+	ntfsCtx     *parser.NTFSContext
+	size        int64
+	initialized int64
+	resident    []byte
+	extents     []Extent
+
+	unitMu     sync.Mutex
+	unitOffset int64
+	unit       []byte
+}
+
+// newStreamReader creates a reader for the $DATA attribute first and its
+// continuation attributes.
+func newStreamReader(ntfsCtx *parser.NTFSContext, entry *parser.MFT_ENTRY, first *parser.NTFS_ATTRIBUTE) (*streamReader, error) {
+	r := &streamReader{ntfsCtx: ntfsCtx, size: first.DataSize()}
+
+	if first.IsResident() {
+		r.resident = make([]byte, r.size)
+		n, err := first.Data(ntfsCtx).ReadAt(r.resident, 0)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		r.resident = r.resident[:n]
+		r.initialized = int64(n)
+		r.extents = []Extent{{Length: int64(n), Resident: true}}
+		return r, nil
+	}
+
+	r.initialized = int64(first.Initialized_size())
+	runs := attributeRuns(ntfsCtx, entry, first)
+	clusterSize := ntfsCtx.ClusterSize
+	if first.Flags().IsSet("COMPRESSED") && first.Compression_unit_size() != 0 {
+		unit := int64(1) << uint(first.Compression_unit_size())
+		r.extents = compressedExtents(runs, unit, clusterSize)
+	} else {
+		for _, run := range runs {
+			r.extents = appendExtent(r.extents, Extent{
+				Offset: run.vcn * clusterSize,
+				Length: run.length * clusterSize,
+				LCN:    run.lcn,
+				Sparse: run.sparse,
+			}, clusterSize)
+		}
+	}
+	r.extents = clipExtents(r.extents, r.size)
+	return r, nil
+}
+
+// attributeRuns returns the data runs of an attribute and its continuation
+// attributes, which contain the runs of large or fragmented streams.
+func attributeRuns(ntfsCtx *parser.NTFSContext, entry *parser.MFT_ENTRY, first *parser.NTFS_ATTRIBUTE) []dataRun {
+	var runs []dataRun
+	for _, attribute := range entry.EnumerateAttributes(ntfsCtx) {
+		if attribute.Type().Value != first.Type().Value || attribute.Attribute_id() != first.Attribute_id() || attribute.IsResident() {
+			continue
+		}
+		vcn := int64(attribute.Runlist_vcn_start())
+		var lcn int64
+		for _, run := range attribute.RunList() {
+			r := dataRun{vcn: vcn, length: run.Length, sparse: run.RelativeUrnOffset == 0}
+			if !r.sparse {
+				lcn += run.RelativeUrnOffset
+				r.lcn = lcn
+			}
+			runs = append(runs, r)
+			vcn += run.Length
+		}
+	}
+	sort.Sort(byVCN(runs))
+	return runs
+}
+
+type byVCN []dataRun
+
+func (r byVCN) Len() int           { return len(r) }
+func (r byVCN) Less(i, j int) bool { return r[i].vcn < r[j].vcn }
+func (r byVCN) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+// compressedExtents splits the runs of a compressed stream into compression
+// units. Units without clusters are sparse, units that use all clusters are
+// stored uncompressed.
+func compressedExtents(runs []dataRun, unit, clusterSize int64) []Extent {
+	var extents []Extent
+	if len(runs) == 0 {
+		return nil
+	}
+	end := runs[len(runs)-1].vcn + runs[len(runs)-1].length
+	for start := int64(0); start < end; start += unit {
+		var clusters []dataRun
+		var allocated int64
+		for _, run := range runs {
+			from, to := run.vcn, run.vcn+run.length
+			if from < start {
+				from = start
+			}
+			if to > start+unit {
+				to = start + unit
+			}
+			if from >= to || run.sparse {
+				continue
+			}
+			part := dataRun{vcn: from, lcn: run.lcn + from - run.vcn, length: to - from}
+			clusters = append(clusters, part)
+			allocated += part.length
+		}
+
+		switch {
+		case allocated == 0:
+			extents = appendExtent(extents, Extent{Offset: start * clusterSize, Length: unit * clusterSize, Sparse: true}, clusterSize)
+		case allocated == unit:
+			for _, part := range clusters {
+				extents = appendExtent(extents, Extent{Offset: part.vcn * clusterSize, Length: part.length * clusterSize, LCN: part.lcn}, clusterSize)
+			}
+		default:
+			extents = append(extents, Extent{
+				Offset:     start * clusterSize,
+				Length:     unit * clusterSize,
+				LCN:        clusters[0].lcn,
+				Compressed: true,
+				clusters:   clusters,
+			})
+		}
+	}
+	return extents
+}
+
+// appendExtent appends an extent and merges it with the previous extent if
+// they are contiguous.
+func appendExtent(extents []Extent, extent Extent, clusterSize int64) []Extent {
+	if len(extents) > 0 {
+		last := &extents[len(extents)-1]
+		contiguous := last.Offset+last.Length == extent.Offset && !last.Compressed && !extent.Compressed
+		switch {
+		case contiguous && last.Sparse && extent.Sparse:
+			last.Length += extent.Length
+			return extents
+		case contiguous && !last.Sparse && !extent.Sparse && last.LCN+last.Length/clusterSize == extent.LCN:
+			last.Length += extent.Length
+			return extents
+		}
+	}
+	return append(extents, extent)
+}
+
+// clipExtents removes the parts of the extents that exceed the stream size.
+func clipExtents(extents []Extent, size int64) []Extent {
+	var clipped []Extent
+	for _, extent := range extents {
+		if extent.Offset >= size {
+			break
+		}
+		if extent.Offset+extent.Length > size {
+			extent.Length = size - extent.Offset
+		}
+		clipped = append(clipped, extent)
+	}
+	return clipped
+}
+
+// ReadAt reads len(p) bytes of the stream starting at off.
+func (r *streamReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	want := len(p)
+	if remaining := r.size - off; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	if r.resident != nil {
+		// resident data that could not be read is beyond the initialized
+		// size and filled with zeros below
+		if off < int64(len(r.resident)) {
+			copy(p, r.resident[off:])
+		}
+		n = len(p)
+	} else {
+		n, err = r.readExtents(p, off)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	// data beyond the initialized size reads as zeros
+	if off+int64(n) > r.initialized {
+		from := r.initialized - off
+		if from < 0 {
+			from = 0
+		}
+		for i := from; i < int64(n); i++ {
+			p[i] = 0
+		}
+	}
+
+	if n < want {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *streamReader) readExtents(p []byte, off int64) (n int, err error) {
+	idx := sort.Search(len(r.extents), func(i int) bool {
+		return r.extents[i].Offset+r.extents[i].Length > off
+	})
+
+	for ; idx < len(r.extents) && n < len(p); idx++ {
+		extent := &r.extents[idx]
+		pos := off + int64(n)
+		if pos < extent.Offset {
+			// missing runs read as zeros
+			gap := extent.Offset - pos
+			if gap > int64(len(p)-n) {
+				gap = int64(len(p) - n)
+			}
+			n += zero(p[n : n+int(gap)])
+			pos += gap
+			if n == len(p) {
+				break
+			}
+		}
+
+		rel := pos - extent.Offset
+		toRead := extent.Length - rel
+		if toRead > int64(len(p)-n) {
+			toRead = int64(len(p) - n)
+		}
+		buf := p[n : n+int(toRead)]
+
+		switch {
+		case extent.Sparse:
+			zero(buf)
+		case extent.Compressed:
+			unit, err := r.decompress(extent)
+			if err != nil {
+				return n, err
+			}
+			zero(buf)
+			if rel < int64(len(unit)) {
+				copy(buf, unit[rel:])
+			}
+		default:
+			m, err := r.ntfsCtx.DiskReader.ReadAt(buf, extent.LCN*r.ntfsCtx.ClusterSize+rel)
+			if err != nil && !(err == io.EOF && m == len(buf)) {
+				return n + m, err
+			}
+		}
+		n += len(buf)
+	}
+
+	// runs after the last extent read as zeros
+	n += zero(p[n:])
+	return n, nil
+}
+
+// decompress reads and decompresses a compression unit. The last unit is
+// cached, as reads are usually sequential.
+func (r *streamReader) decompress(extent *Extent) ([]byte, error) {
+	r.unitMu.Lock()
+	unit, unitOffset := r.unit, r.unitOffset
+	r.unitMu.Unlock()
+	if unit != nil && unitOffset == extent.Offset {
+		return unit, nil
+	}
+
+	var compressed []byte
+	for _, run := range extent.clusters {
+		buf := make([]byte, run.length*r.ntfsCtx.ClusterSize)
+		n, err := r.ntfsCtx.DiskReader.ReadAt(buf, run.lcn*r.ntfsCtx.ClusterSize)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		compressed = append(compressed, buf[:n]...)
+	}
+
+	unit, err := parser.LZNT1Decompress(compressed)
+	if err != nil {
+		return nil, err
+	}
+	r.unitMu.Lock()
+	r.unit, r.unitOffset = unit, extent.Offset
+	r.unitMu.Unlock()
+	return unit, nil
+}
+
+func zero(p []byte) int {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p)
+}
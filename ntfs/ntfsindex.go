@@ -0,0 +1,220 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package ntfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+	"unicode/utf16"
+
+	"github.com/forensicanalysis/fslib/ntfs/internal/ntfsutil"
+)
+
+const (
+	bitmapAttributeType = 0xB0
+	indexName           = "$I30"
+
+	// fileNameSize is the size of a $FILE_NAME attribute without the name.
+	fileNameSize = 66
+)
+
+// IndexEntry is a directory entry of an $I30 index. It contains a copy of the
+// $FILE_NAME attribute of the file.
+type IndexEntry struct {
+	Entry          uint64
+	Sequence       uint16
+	ParentEntry    uint64
+	ParentSequence uint16
+	Name           string
+	// Namespace is 0 for POSIX, 1 for Win32, 2 for DOS and 3 for Win32 and
+	// DOS names.
+	Namespace uint8
+
+	Created     time.Time
+	Modified    time.Time
+	MFTModified time.Time
+	Accessed    time.Time
+
+	AllocatedSize int64
+	Size          int64
+	Attributes    uint32
+
+	// Offset of the $FILE_NAME attribute in the index allocation.
+	Offset int64
+	// Recovered is set for entries that were carved from index slack. They
+	// are not part of the live index and may refer to deleted files or to
+	// previous names of existing files.
+	Recovered bool
+}
+
+// IndexSlack carves directory entries from the slack space of the $I30 index
+// records of a directory. That is the space behind the last entry of each
+// index record and index records that are not in use. Candidates are
+// validated by their parent reference, which must point to the directory,
+// their timestamps and their name.
+func (i *Item) IndexSlack() ([]*IndexEntry, error) {
+	if !i.entry.Flags().IsSet("DIRECTORY") {
+		return nil, errors.New("not a directory")
+	}
+
+	root := namedAttribute(i.ntfsCtx, i.entry, indexRootAttributeType, indexName)
+	allocation := namedAttribute(i.ntfsCtx, i.entry, indexAllocationAttributeType, indexName)
+	if root == nil || allocation == nil {
+		return nil, nil // small directories only have an index root
+	}
+
+	rootData := make([]byte, 16)
+	if _, err := root.Data(i.ntfsCtx).ReadAt(rootData, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	recordSize := int64(binary.LittleEndian.Uint32(rootData[8:]))
+	if !validIndexRecordSize(recordSize) {
+		return nil, errors.New("invalid index record size")
+	}
+
+	r, err := newStreamReader(i.ntfsCtx, i.entry, allocation)
+	if err != nil {
+		return nil, err
+	}
+
+	// without $BITMAP all index records are considered in use
+	var bitmap []byte
+	if attribute := namedAttribute(i.ntfsCtx, i.entry, bitmapAttributeType, indexName); attribute != nil {
+		size := attribute.DataSize()
+		if records := (r.size/recordSize + 7) / 8; size < 0 || size > records {
+			size = records
+		}
+		bitmap = make([]byte, size)
+		n, err := attribute.Data(i.ntfsCtx).ReadAt(bitmap, 0)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		bitmap = bitmap[:n]
+	}
+
+	var entries []*IndexEntry
+	directory := uint64(i.entry.Record_number())
+	record := make([]byte, recordSize)
+	for offset := int64(0); offset+recordSize <= r.size; offset += recordSize {
+		if _, err := r.ReadAt(record, offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		if string(record[:4]) != "INDX" || ntfsutil.Fixup(record) != nil {
+			continue
+		}
+
+		entriesOffset := 24 + int(binary.LittleEndian.Uint32(record[24:]))
+		slackStart := 24 + int(binary.LittleEndian.Uint32(record[28:]))
+		if index := offset / recordSize; !indexRecordInUse(bitmap, index) {
+			slackStart = entriesOffset // unused index record
+		}
+		for _, entry := range carveIndexEntries(record, slackStart, directory) {
+			entry.Offset += offset
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// indexRecordInUse returns if an index record is marked as in use in the
+// $BITMAP attribute of an index. All records are in use if the index has no
+// bitmap.
+func indexRecordInUse(bitmap []byte, index int64) bool {
+	if bitmap == nil {
+		return true
+	}
+	if index < 0 || index/8 >= int64(len(bitmap)) {
+		return false
+	}
+	return bitmap[index/8]&(1<<uint(index%8)) != 0
+}
+
+// carveIndexEntries searches $FILE_NAME attributes with a parent reference to
+// directory in record, starting at offset start.
+func carveIndexEntries(record []byte, start int, directory uint64) []*IndexEntry {
+	var entries []*IndexEntry
+	if start < 16 {
+		start = 16
+	}
+	// index entries are aligned to 8 bytes, the $FILE_NAME attribute starts
+	// after the 16 byte entry header
+	for offset := (start + 7) &^ 7; offset+fileNameSize <= len(record); offset += 8 {
+		entry, length := parseIndexFileName(record[offset:], directory)
+		if entry == nil {
+			continue
+		}
+		entry.Entry, entry.Sequence = fileReference(record[offset-16:])
+		entry.Offset = int64(offset)
+		entry.Recovered = true
+		entries = append(entries, entry)
+		offset += (length+7)&^7 - 8
+	}
+	return entries
+}
+
+// parseIndexFileName parses a $FILE_NAME attribute if it looks valid. It
+// returns the entry and the length of the attribute.
+func parseIndexFileName(data []byte, directory uint64) (*IndexEntry, int) {
+	parent, parentSequence := fileReference(data)
+	if parent != directory || parentSequence == 0 {
+		return nil, 0
+	}
+	nameLength := int(data[64])
+	namespace := data[65]
+	length := fileNameSize + nameLength*2
+	if nameLength == 0 || namespace > 3 || length > len(data) {
+		return nil, 0
+	}
+
+	var times [4]time.Time
+	for i := range times {
+		times[i] = ntfsutil.Filetime(binary.LittleEndian.Uint64(data[8+i*8:]))
+		if times[i].Year() < 1980 || times[i].Year() > 2100 {
+			return nil, 0
+		}
+	}
+
+	chars := make([]uint16, nameLength)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(data[fileNameSize+i*2:])
+		if chars[i] == 0 || chars[i] == '/' {
+			return nil, 0
+		}
+	}
+
+	return &IndexEntry{
+		ParentEntry:    parent,
+		ParentSequence: parentSequence,
+		Name:           string(utf16.Decode(chars)),
+		Namespace:      namespace,
+		Created:        times[0],
+		Modified:       times[1],
+		MFTModified:    times[2],
+		Accessed:       times[3],
+		AllocatedSize:  int64(binary.LittleEndian.Uint64(data[40:])),
+		Size:           int64(binary.LittleEndian.Uint64(data[48:])),
+		Attributes:     binary.LittleEndian.Uint32(data[56:]),
+	}, length
+}
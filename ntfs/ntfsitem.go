@@ -1,23 +1,32 @@
 package ntfs
 
 import (
+	"errors"
 	"io"
 	"io/fs"
 	"os"
 	"strings"
+	"sync"
 
 	"www.velocidex.com/golang/go-ntfs/parser"
 )
 
-// Item describes files and directories in the NTFS.
+// Item describes files and directories in the NTFS. ReadAt is safe for
+// concurrent use. Read, Seek and ReadDir move the offset of the item and
+// must not be called concurrently, like the methods of *os.File.
 type Item struct {
-	entry     *parser.MFT_ENTRY
-	size      *int64
-	attribute *parser.NTFS_ATTRIBUTE
+	entry *parser.MFT_ENTRY
+
+	mu     sync.Mutex // guards size and reader
+	size   *int64
+	reader *streamReader
+
 	name      string
+	stream    string
 	offset    int64
 	dirOffset int
 	path      string
+	fsys      *FS
 	ntfsCtx   *parser.NTFSContext
 }
 
@@ -31,41 +40,66 @@ func (i *Item) Read(p []byte) (n int, err error) {
 	return c, err
 }
 
-// ReadAt reads bytes starting at off into passed buffer.
+// ReadAt reads bytes starting at off into passed buffer. Sparse ranges and
+// ranges beyond the initialized size of the stream read as zeros.
 func (i *Item) ReadAt(p []byte, off int64) (n int, err error) {
-	if i.attribute == nil {
-		attribute, err := i.entry.GetAttribute(i.ntfsCtx, 128, -1)
-		if err != nil {
-			return 0, err
-		}
-		i.attribute = attribute
+	r, err := i.streamReader()
+	if err != nil {
+		return 0, err
 	}
+	return r.ReadAt(p, off)
+}
 
-	n, err = i.attribute.Data(i.ntfsCtx).ReadAt(p, off)
-	if int64(len(p)) > i.Size() {
-		err = io.EOF
+// streamReader returns the reader for the item's stream.
+func (i *Item) streamReader() (*streamReader, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.reader == nil {
+		attribute, err := dataAttribute(i.ntfsCtx, i.entry, i.stream)
+		if err != nil {
+			return nil, err
+		}
+		r, err := newStreamReader(i.ntfsCtx, i.entry, attribute)
+		if err != nil {
+			return nil, err
+		}
+		i.reader = r
 	}
-	return
+	return i.reader, nil
 }
 
 // Seek move the current offset to the given position.
 func (i *Item) Seek(pos int64, whence int) (offset int64, err error) {
-	if pos != 0 {
-		switch whence {
-		case os.SEEK_SET:
-			i.offset = pos
-		case os.SEEK_CUR:
-			i.offset += pos
-		case os.SEEK_END:
-			i.offset = i.Size() + pos
-		}
+	switch whence {
+	case os.SEEK_SET:
+		offset = pos
+	case os.SEEK_CUR:
+		offset = i.offset + pos
+	case os.SEEK_END:
+		offset = i.Size() + pos
+	default:
+		return i.offset, errors.New("invalid whence")
 	}
-
+	if offset < 0 {
+		return i.offset, errors.New("negative offset")
+	}
+	i.offset = offset
 	return i.offset, nil
 }
 
 // Size returns the item's size.
 func (i *Item) Size() int64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.size == nil && (i.stream != "" || !i.entry.Flags().IsSet("DIRECTORY")) {
+		// the size of files is the size of the unnamed stream, even if
+		// other streams are larger, e.g. $Bad of $BadClus
+		var size int64
+		if attribute, err := dataAttribute(i.ntfsCtx, i.entry, i.stream); err == nil {
+			size = attribute.DataSize()
+		}
+		i.size = &size
+	}
 	if i.size == nil {
 		infos, err := parser.ModelMFTEntry(i.ntfsCtx, i.entry)
 		if err != nil {
@@ -78,13 +112,11 @@ func (i *Item) Size() int64 {
 
 // ReadDir returns up to n child items of a directory.
 func (i *Item) ReadDir(n int) (entries []fs.DirEntry, err error) {
-	infos := parser.ListDir(i.ntfsCtx, i.entry)
-
-	for _, info := range infos {
-		if info.Name == "" || info.Name == "." || strings.Contains(info.Name, ":") {
+	for _, entry := range i.dirEntries() {
+		if entry.info.Name == "" || entry.info.Name == "." || strings.Contains(entry.info.Name, ":") {
 			continue
 		}
-		entries = append(entries, &DirEntry{info})
+		entries = append(entries, entry)
 	}
 
 	// directory already exhausted
@@ -108,44 +140,101 @@ func (i *Item) ReadDir(n int) (entries []fs.DirEntry, err error) {
 	return entries, err
 }
 
+// dirEntries lists the children of a directory like parser.ListDir, but keeps
+// the file attribute flags of their index entries.
+func (i *Item) dirEntries() []*DirEntry {
+	// the index contains a $FILE_NAME entry for every name of an MFT entry
+	seen := make(map[uint64]bool)
+	var entries []*DirEntry
+	for _, node := range i.entry.Dir(i.ntfsCtx) {
+		reference := node.MftReference()
+		if seen[reference] {
+			continue
+		}
+		seen[reference] = true
+
+		child, err := i.ntfsCtx.GetMFT(int64(reference))
+		if err != nil {
+			continue
+		}
+		attributes := uint32(node.File().Flags().Value)
+		for _, info := range parser.Stat(i.ntfsCtx, child) {
+			entry := newDirEntry(i.fsys, info)
+			entry.attributes = &attributes
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
 // Close does not do anything for NTFS items.
 func (i *Item) Close() error { return nil }
 
 // Stat returns the MBR pseudo roots itself as fs.FileMode.
 func (i *Item) Stat() (fs.FileInfo, error) {
 	infos := parser.Stat(i.ntfsCtx, i.entry)
+	if i.stream == "" {
+		return newDirEntry(i.fsys, infos[0]), nil
+	}
 
-	return &DirEntry{infos[0]}, nil
+	for _, info := range infos {
+		if !strings.HasSuffix(strings.ToLower(info.Name), ":"+strings.ToLower(i.stream)) {
+			continue
+		}
+		streamInfo := *info
+		streamInfo.Name = i.Name()
+		streamInfo.IsDir = false
+		return newDirEntry(i.fsys, &streamInfo), nil
+	}
+	return nil, fs.ErrNotExist
 }
 
-/*
-// IsDir returns if the item is a file.
-func (i *Item) IsDir() bool { return i.entry.IsDir(i.ntfsCtx) }
+// Name returns the name of the item. Alternate data streams are named
+// "file:stream".
+func (i *Item) Name() string {
+	if i.stream != "" {
+		return i.name + ":" + i.stream
+	}
+	return i.name
+}
 
-// ModTime returns the zero time (0001-01-01 00:00).
-func (i *Item) ModTime() time.Time {
-	return time.Time{}
+// Stream describes a named $DATA attribute of an MFT entry.
+type Stream struct {
+	Name string
+	Size int64
 }
 
-// Mode returns the fs.FileMode.
-func (i *Item) Mode() fs.FileMode {
-	if i.IsDir() {
-		return fs.ModeDir
+// Streams returns the alternate data streams of the item.
+func (i *Item) Streams() []Stream {
+	var streams []Stream
+	for _, attribute := range i.entry.EnumerateAttributes(i.ntfsCtx) {
+		if attribute.Type().Value != dataAttributeType || attribute.Name() == "" || !isFirstVCN(attribute) {
+			continue
+		}
+		streams = append(streams, Stream{Name: attribute.Name(), Size: attribute.DataSize()})
 	}
-	return 0
+	return streams
 }
 
-// Sys returns a map of NTFS item attributes.
-func (i *Item) Sys() interface{} {
-	infos, err := parser.ModelMFTEntry(i.ntfsCtx, i.entry)
-	if err != nil {
-		return map[string]interface{}{}
-	}
-	return map[string]interface{}{
-		"created":     infos.SI_Times.CreateTime.UTC().Format(time.RFC3339Nano),
-		"modified":    infos.SI_Times.FileModifiedTime.UTC().Format(time.RFC3339Nano),
-		"mftModified": infos.SI_Times.MFTModifiedTime.UTC().Format(time.RFC3339Nano),
-		"accessed":    infos.SI_Times.AccessedTime.UTC().Format(time.RFC3339Nano),
+const dataAttributeType = 128
+
+// isFirstVCN returns false for the continuation attributes of large
+// non-resident streams that are split across multiple attributes.
+func isFirstVCN(attribute *parser.NTFS_ATTRIBUTE) bool {
+	return attribute.IsResident() || attribute.Runlist_vcn_start() == 0
+}
+
+// dataAttribute returns the first $DATA attribute with the given name. The
+// unnamed attribute contains the default stream. Stream names are case
+// insensitive.
+func dataAttribute(ntfsCtx *parser.NTFSContext, entry *parser.MFT_ENTRY, stream string) (*parser.NTFS_ATTRIBUTE, error) {
+	for _, attribute := range entry.EnumerateAttributes(ntfsCtx) {
+		if attribute.Type().Value != dataAttributeType || !isFirstVCN(attribute) {
+			continue
+		}
+		if strings.EqualFold(attribute.Name(), stream) {
+			return attribute, nil
+		}
 	}
+	return nil, errors.New("data stream not found")
 }
-*/
@@ -0,0 +1,207 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package ntfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+const (
+	rootEntry   = 5
+	bitmapEntry = 6
+
+	// orphanDir is the path prefix of entries whose parent directory does
+	// not exist anymore.
+	orphanDir = "$OrphanFiles"
+)
+
+// OpenMFT opens a file by its MFT reference, which consists of the entry
+// number and the sequence number. The sequence number is incremented every
+// time an MFT entry is reused, so references to deleted files fail. A
+// sequence number of 0 is not validated.
+func (fsys *FS) OpenMFT(entry uint64, sequence uint16) (fs.File, error) {
+	mftEntry, err := fsys.ntfsCtx.GetMFT(int64(entry))
+	if err != nil {
+		return nil, err
+	}
+	if sequence != 0 && mftEntry.Sequence_value() != sequence {
+		return nil, fmt.Errorf("MFT entry %d has sequence number %d, not %d", entry, mftEntry.Sequence_value(), sequence)
+	}
+	return fsys.newItem(mftEntry), nil
+}
+
+// PathOf returns the path of an MFT entry. It is resolved through the parent
+// references of the $FILE_NAME attributes. Paths of files whose parent
+// directory does not exist anymore start with $OrphanFiles.
+func (fsys *FS) PathOf(entry uint64) (string, error) {
+	mftEntry, err := fsys.ntfsCtx.GetMFT(int64(entry))
+	if err != nil {
+		return "", err
+	}
+	if fileName(fsys.ntfsCtx, mftEntry) == nil {
+		return "", errors.New("MFT entry has no file name")
+	}
+	return fsys.fullPath(mftEntry), nil
+}
+
+// HardLinks returns the paths of all hard links of an MFT entry. Every hard
+// link has its own $FILE_NAME attribute with a parent reference. DOS 8.3
+// names are aliases and not listed.
+func (fsys *FS) HardLinks(entry uint64) ([]string, error) {
+	mftEntry, err := fsys.ntfsCtx.GetMFT(int64(entry))
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	seen := map[string]bool{}
+	for _, name := range mftEntry.FileName(fsys.ntfsCtx) {
+		if name.NameType().Name == "DOS" {
+			continue
+		}
+		dir := orphanDir
+		parent, err := fsys.ntfsCtx.GetMFT(int64(name.MftReference()))
+		if err == nil && isParent(parent, name.Seq_num()) {
+			dir = fsys.fullPath(parent)
+		}
+		p := path.Join(dir, name.Name())
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, errors.New("MFT entry has no file name")
+	}
+	return paths, nil
+}
+
+// newItem creates an Item for an MFT entry that is named by its path.
+func (fsys *FS) newItem(entry *parser.MFT_ENTRY) *Item {
+	name := fsys.fullPath(entry)
+	return &Item{entry: entry, name: path.Base(name), path: path.Join("/", name), fsys: fsys, ntfsCtx: fsys.ntfsCtx}
+}
+
+// fileName returns the preferred $FILE_NAME attribute of an MFT entry. Long
+// names are preferred over DOS 8.3 names.
+func fileName(ntfsCtx *parser.NTFSContext, entry *parser.MFT_ENTRY) *parser.FILE_NAME {
+	var result *parser.FILE_NAME
+	for _, name := range entry.FileName(ntfsCtx) {
+		if name.NameType().Name != "DOS" {
+			return name
+		}
+		if result == nil {
+			result = name
+		}
+	}
+	return result
+}
+
+// fullPath rebuilds the path of an MFT entry from the parent references of
+// its $FILE_NAME attributes. The path of entries whose parent directory was
+// deleted and reused starts with $OrphanFiles.
+func (fsys *FS) fullPath(entry *parser.MFT_ENTRY) string {
+	var parts []string
+	seen := map[uint32]bool{}
+	for entry.Record_number() != rootEntry {
+		id := entry.Record_number()
+		name := fileName(fsys.ntfsCtx, entry)
+		if seen[id] || name == nil {
+			return path.Join(append([]string{orphanDir}, parts...)...)
+		}
+		seen[id] = true
+		parts = append([]string{name.Name()}, parts...)
+
+		parent, err := fsys.ntfsCtx.GetMFT(int64(name.MftReference()))
+		if err != nil || !isParent(parent, name.Seq_num()) {
+			return path.Join(append([]string{orphanDir}, parts...)...)
+		}
+		entry = parent
+	}
+	return path.Join(append([]string{"."}, parts...)...)
+}
+
+// isParent returns if the directory entry matches the sequence number of a
+// parent reference. The sequence number is incremented when an entry is
+// deleted, so deleted directories match the previous sequence number.
+func isParent(parent *parser.MFT_ENTRY, sequence uint16) bool {
+	if !parent.Flags().IsSet("DIRECTORY") {
+		return false
+	}
+	if parent.Sequence_value() == sequence {
+		return true
+	}
+	return !parent.Flags().IsSet("ALLOCATED") && parent.Sequence_value() == sequence+1
+}
+
+// entryCount returns the number of records in the $MFT.
+func (fsys *FS) entryCount() int64 {
+	mft, err := fsys.ntfsCtx.GetMFT(0)
+	if err != nil {
+		return 0
+	}
+	attribute, err := dataAttribute(fsys.ntfsCtx, mft, "")
+	if err != nil {
+		return 0
+	}
+	return attribute.DataSize() / fsys.ntfsCtx.GetRecordSize()
+}
+
+// clusterBitmap returns the content of $Bitmap, which contains one bit per
+// cluster that is set for allocated clusters.
+func (fsys *FS) clusterBitmap() ([]byte, error) {
+	fsys.bitmapOnce.Do(func() {
+		entry, err := fsys.ntfsCtx.GetMFT(bitmapEntry)
+		if err != nil {
+			fsys.bitmapErr = err
+			return
+		}
+		attribute, err := dataAttribute(fsys.ntfsCtx, entry, "")
+		if err != nil {
+			fsys.bitmapErr = err
+			return
+		}
+		bitmap := make([]byte, attribute.DataSize())
+		n, err := attribute.Data(fsys.ntfsCtx).ReadAt(bitmap, 0)
+		if err != nil && err != io.EOF {
+			fsys.bitmapErr = err
+			return
+		}
+		fsys.bitmap = bitmap[:n]
+	})
+	return fsys.bitmap, fsys.bitmapErr
+}
+
+// clusterAllocated returns if a cluster is marked as allocated in $Bitmap.
+func clusterAllocated(bitmap []byte, cluster int64) bool {
+	if cluster < 0 || cluster/8 >= int64(len(bitmap)) {
+		return false
+	}
+	return bitmap[cluster/8]&(1<<uint(cluster%8)) != 0
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package ntfs
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+	"unicode/utf16"
+
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+const defaultPathCacheSize = 4096
+
+// pathReference is a cached MFT reference of a directory.
+type pathReference struct {
+	entry    uint64
+	sequence uint16
+	// path is the resolved path, which is the path as stored on disk if
+	// paths are resolved case-insensitively.
+	path string
+}
+
+// resolve resolves a slash separated path starting at the root directory. The
+// references of directories are cached, so only the components below the
+// deepest cached directory are searched. It returns the MFT entry and the
+// resolved path.
+func (fsys *FS) resolve(name string) (*parser.MFT_ENTRY, string, error) {
+	var components []string
+	for _, component := range strings.Split(path.Clean(name), "/") {
+		if component != "" && component != "." {
+			components = append(components, component)
+		}
+	}
+
+	entry, resolved, depth := fsys.cachedDirectory(components)
+	if entry == nil {
+		var err error
+		if entry, err = fsys.ntfsCtx.GetMFT(rootEntry); err != nil {
+			return nil, "", err
+		}
+	}
+
+	for ; depth < len(components); depth++ {
+		child, name, err := fsys.child(entry, components[depth])
+		if err != nil {
+			return nil, "", err
+		}
+		entry = child
+		resolved = path.Join(resolved, name)
+
+		if entry.Flags().IsSet("DIRECTORY") {
+			fsys.pathCache.Add(fsys.cacheKey(components[:depth+1]), &pathReference{
+				entry:    uint64(entry.Record_number()),
+				sequence: entry.Sequence_value(),
+				path:     resolved,
+			})
+		}
+	}
+	return entry, resolved, nil
+}
+
+// cachedDirectory returns the deepest cached directory of the parent
+// directories of a path, its resolved path and the number of components it
+// covers.
+func (fsys *FS) cachedDirectory(components []string) (*parser.MFT_ENTRY, string, int) {
+	for depth := len(components) - 1; depth > 0; depth-- {
+		key := fsys.cacheKey(components[:depth])
+		value, ok := fsys.pathCache.Get(key)
+		if !ok {
+			continue
+		}
+		reference := value.(*pathReference)
+		entry, err := fsys.ntfsCtx.GetMFT(int64(reference.entry))
+		if err != nil || entry.Sequence_value() != reference.sequence {
+			fsys.pathCache.Remove(key)
+			continue
+		}
+		return entry, reference.path, depth
+	}
+	return nil, "/.", 0
+}
+
+// child searches a directory for a name. It returns the MFT entry and the
+// name as stored on disk if paths are resolved case-insensitively.
+func (fsys *FS) child(dir *parser.MFT_ENTRY, name string) (*parser.MFT_ENTRY, string, error) {
+	if !fsys.options.CaseInsensitive {
+		entry, err := dir.Open(fsys.ntfsCtx, name)
+		return entry, name, err
+	}
+
+	upcase, err := fsys.upcaseTable()
+	if err != nil {
+		return nil, "", err
+	}
+	key := upcaseName(upcase, utf16.Encode([]rune(name)))
+	reference, sequence, onDisk, err := fsys.indexLookup(dir, key, upcase)
+	if err != nil {
+		return nil, "", err
+	}
+	entry, err := fsys.ntfsCtx.GetMFT(int64(reference))
+	if err != nil {
+		return nil, "", err
+	}
+	// stale index entries reference records that were reused for other files
+	if entry.Sequence_value() != sequence {
+		return nil, "", fs.ErrNotExist
+	}
+	return entry, onDisk, nil
+}
+
+// cacheKey normalizes a path like the name comparison of child does.
+func (fsys *FS) cacheKey(components []string) string {
+	key := strings.Join(components, "/")
+	if !fsys.options.CaseInsensitive {
+		return strings.ToLower(key)
+	}
+	upcase, err := fsys.upcaseTable()
+	if err != nil {
+		return key
+	}
+	return string(utf16.Decode(upcaseName(upcase, utf16.Encode([]rune(key)))))
+}
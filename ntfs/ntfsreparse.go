@@ -0,0 +1,180 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package ntfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"strings"
+
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+const (
+	reparsePointAttributeType = 0xC0
+
+	// fileAttributeReparsePoint is set in the $STANDARD_INFORMATION and
+	// $FILE_NAME flags of entries with a $REPARSE_POINT attribute.
+	fileAttributeReparsePoint = 0x400
+
+	// maxReparseDataSize is the maximum size of reparse data,
+	// MAXIMUM_REPARSE_DATA_BUFFER_SIZE.
+	maxReparseDataSize = 16 * 1024
+)
+
+// Reparse tags of common reparse points.
+const (
+	ReparseTagMountPoint  = 0xA0000003
+	ReparseTagSymlink     = 0xA000000C
+	ReparseTagDedup       = 0x80000013
+	ReparseTagWOF         = 0x80000017
+	ReparseTagCloud       = 0x9000001A
+	ReparseTagAppExecLink = 0x8000001B
+	ReparseTagLXSymlink   = 0xA000001D
+
+	// reparseTagCloudMask masks the subtypes of cloud files placeholders,
+	// e.g. 0x9000101A for OneDrive.
+	reparseTagCloudMask = 0xFFFF0FFF
+)
+
+// ReparsePoint is the content of a $REPARSE_POINT attribute.
+type ReparsePoint struct {
+	Tag uint32
+	// Target is the substitute name of symbolic links and junctions
+	// without the \??\ prefix, e.g. C:\Users\Public. It is a Windows path
+	// and may be relative for symbolic links.
+	Target string
+	// PrintName is the name of the target that is displayed to users.
+	PrintName string
+	// Relative is set for relative symbolic links.
+	Relative bool
+	// Data contains the raw reparse data without the 8 byte header.
+	Data []byte
+}
+
+// IsLink returns if the reparse point is a symbolic link or a junction.
+func (r *ReparsePoint) IsLink() bool {
+	return isLinkTag(r.Tag)
+}
+
+func isLinkTag(tag uint32) bool {
+	switch tag {
+	case ReparseTagSymlink, ReparseTagMountPoint, ReparseTagLXSymlink:
+		return true
+	}
+	return false
+}
+
+// IsCloud returns if the reparse point is a placeholder of a cloud file, e.g.
+// a OneDrive file that is not available locally.
+func (r *ReparsePoint) IsCloud() bool {
+	return r.Tag&reparseTagCloudMask == ReparseTagCloud
+}
+
+// ReadLink returns the target of a symbolic link or a junction.
+func (fsys *FS) ReadLink(name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	reparsePoint, err := f.(*Item).ReparsePoint()
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	if !reparsePoint.IsLink() {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: errors.New("not a symbolic link")}
+	}
+	return reparsePoint.Target, nil
+}
+
+// ReparsePoint returns the reparse point of the item.
+func (i *Item) ReparsePoint() (*ReparsePoint, error) {
+	return entryReparsePoint(i.ntfsCtx, i.entry)
+}
+
+// entryReparsePoint reads the $REPARSE_POINT attribute of an MFT entry.
+func entryReparsePoint(ntfsCtx *parser.NTFSContext, entry *parser.MFT_ENTRY) (*ReparsePoint, error) {
+	data, err := attributeData(ntfsCtx, entry, reparsePointAttributeType, maxReparseDataSize)
+	if err != nil {
+		return nil, err
+	}
+	return parseReparsePoint(data)
+}
+
+// parseReparsePoint parses the reparse data buffer of a $REPARSE_POINT
+// attribute.
+func parseReparsePoint(data []byte) (*ReparsePoint, error) {
+	if len(data) < 8 {
+		return nil, errors.New("reparse point too short")
+	}
+	length := int(binary.LittleEndian.Uint16(data[4:]))
+	if 8+length > len(data) {
+		return nil, errors.New("reparse data out of bounds")
+	}
+	r := &ReparsePoint{Tag: binary.LittleEndian.Uint32(data), Data: data[8 : 8+length]}
+
+	switch r.Tag {
+	case ReparseTagMountPoint, ReparseTagSymlink:
+		buffer := 8
+		if r.Tag == ReparseTagSymlink {
+			if len(r.Data) < 12 {
+				return nil, errors.New("reparse data too short")
+			}
+			r.Relative = binary.LittleEndian.Uint32(r.Data[8:])&1 != 0
+			buffer = 12
+		}
+		if len(r.Data) < buffer {
+			return nil, errors.New("reparse data too short")
+		}
+		names := r.Data[buffer:]
+		target, err := reparseName(names, r.Data)
+		if err != nil {
+			return nil, err
+		}
+		r.Target = strings.TrimPrefix(target, `\??\`)
+		if r.PrintName, err = reparseName(names, r.Data[4:]); err != nil {
+			return nil, err
+		}
+	case ReparseTagLXSymlink:
+		// version followed by the UTF-8 target
+		if len(r.Data) < 4 {
+			return nil, errors.New("reparse data too short")
+		}
+		r.Target = string(r.Data[4:])
+		r.PrintName = r.Target
+	}
+	return r, nil
+}
+
+// reparseName decodes the UTF-16 name referenced by the offset and length at
+// header from buffer.
+func reparseName(buffer, header []byte) (string, error) {
+	offset := int(binary.LittleEndian.Uint16(header))
+	length := int(binary.LittleEndian.Uint16(header[2:]))
+	if offset+length > len(buffer) {
+		return "", errors.New("reparse name out of bounds")
+	}
+	return decodeUTF16(buffer[offset : offset+length]), nil
+}
@@ -0,0 +1,363 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package ntfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/forensicanalysis/fslib/ntfs/internal/ntfsutil"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+const (
+	secureEntry = 9
+
+	securityDescriptorAttributeType = 0x50
+	indexRootAttributeType          = 0x90
+	indexAllocationAttributeType    = 0xA0
+
+	sdsHeaderSize = 20
+	// maxSecurityDescriptorSize limits the allocation for security
+	// descriptors of damaged entries. Windows limits ACLs to 64 KiB.
+	maxSecurityDescriptorSize = 64 * 1024
+)
+
+// ACE types.
+const (
+	ACEAccessAllowed  = 0x00
+	ACEAccessDenied   = 0x01
+	ACESystemAudit    = 0x02
+	ACESystemAlarm    = 0x03
+	ACEMandatoryLabel = 0x11
+)
+
+// SID is a security identifier in its string form, e.g. S-1-5-18.
+type SID string
+
+// Name returns the name of a well-known SID, e.g. "Local System" for
+// S-1-5-18. It returns an empty string for other SIDs.
+func (s SID) Name() string {
+	if name, ok := wellKnownSIDs[string(s)]; ok {
+		return name
+	}
+	if strings.HasPrefix(string(s), "S-1-5-21-") {
+		parts := strings.Split(string(s), "-")
+		if name, ok := wellKnownRIDs[parts[len(parts)-1]]; ok && len(parts) == 8 {
+			return name
+		}
+	}
+	return ""
+}
+
+var wellKnownSIDs = map[string]string{
+	"S-1-0-0":      "Nobody",
+	"S-1-1-0":      "Everyone",
+	"S-1-2-0":      "Local",
+	"S-1-2-1":      "Console Logon",
+	"S-1-3-0":      "Creator Owner",
+	"S-1-3-1":      "Creator Group",
+	"S-1-3-4":      "Owner Rights",
+	"S-1-5-1":      "Dialup",
+	"S-1-5-2":      "Network",
+	"S-1-5-3":      "Batch",
+	"S-1-5-4":      "Interactive",
+	"S-1-5-6":      "Service",
+	"S-1-5-7":      "Anonymous",
+	"S-1-5-9":      "Enterprise Domain Controllers",
+	"S-1-5-10":     "Principal Self",
+	"S-1-5-11":     "Authenticated Users",
+	"S-1-5-12":     "Restricted Code",
+	"S-1-5-13":     "Terminal Server Users",
+	"S-1-5-14":     "Remote Interactive Logon",
+	"S-1-5-18":     "Local System",
+	"S-1-5-19":     "Local Service",
+	"S-1-5-20":     "Network Service",
+	"S-1-5-32-544": "Administrators",
+	"S-1-5-32-545": "Users",
+	"S-1-5-32-546": "Guests",
+	"S-1-5-32-547": "Power Users",
+	"S-1-5-32-548": "Account Operators",
+	"S-1-5-32-549": "Server Operators",
+	"S-1-5-32-550": "Print Operators",
+	"S-1-5-32-551": "Backup Operators",
+	"S-1-5-32-552": "Replicators",
+	"S-1-5-32-555": "Remote Desktop Users",
+	"S-1-5-32-573": "Event Log Readers",
+	"S-1-5-80-0":   "All Services",
+	"S-1-5-80-956008885-3418522649-1831038044-1853292631-2271478464": "TrustedInstaller",
+	"S-1-15-2-1":   "All Application Packages",
+	"S-1-15-2-2":   "All Restricted Application Packages",
+	"S-1-16-0":     "Untrusted Mandatory Level",
+	"S-1-16-4096":  "Low Mandatory Level",
+	"S-1-16-8192":  "Medium Mandatory Level",
+	"S-1-16-12288": "High Mandatory Level",
+	"S-1-16-16384": "System Mandatory Level",
+}
+
+// wellKnownRIDs are the relative identifiers of well-known accounts and
+// groups of a domain or a computer (S-1-5-21-x-y-z-RID).
+var wellKnownRIDs = map[string]string{
+	"500": "Administrator",
+	"501": "Guest",
+	"502": "KRBTGT",
+	"512": "Domain Admins",
+	"513": "Domain Users",
+	"514": "Domain Guests",
+	"515": "Domain Computers",
+	"516": "Domain Controllers",
+	"519": "Enterprise Admins",
+}
+
+// ACE is an access control entry.
+type ACE struct {
+	Type  uint8
+	Flags uint8
+	Mask  uint32
+	SID   SID
+}
+
+// SecurityDescriptor is an entry of the $Secure:$SDS stream.
+type SecurityDescriptor struct {
+	ID    uint32
+	Owner SID
+	Group SID
+	DACL  []ACE
+}
+
+// entrySecurityDescriptor returns the security descriptor of an MFT entry.
+// Volumes created by Windows NT and some system files store it in a
+// $SECURITY_DESCRIPTOR attribute instead of $Secure.
+func (fsys *FS) entrySecurityDescriptor(entry *parser.MFT_ENTRY, id uint32) (*SecurityDescriptor, error) {
+	if attribute := namedAttribute(fsys.ntfsCtx, entry, securityDescriptorAttributeType, ""); attribute != nil {
+		size := attribute.DataSize()
+		if size < 0 || size > maxSecurityDescriptorSize {
+			return nil, errors.New("invalid security descriptor size")
+		}
+		data := make([]byte, size)
+		n, err := attribute.Data(fsys.ntfsCtx).ReadAt(data, 0)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return parseSecurityDescriptor(data[:n])
+	}
+	if id == 0 {
+		return nil, errors.New("no security descriptor")
+	}
+	return fsys.securityDescriptor(id)
+}
+
+// securityDescriptor returns the security descriptor with the given id from
+// $Secure. The $SII index is read once.
+func (fsys *FS) securityDescriptor(id uint32) (*SecurityDescriptor, error) {
+	fsys.secureOnce.Do(func() {
+		fsys.secureIndex, fsys.sds, fsys.secureErr = fsys.readSecure()
+	})
+	if fsys.secureErr != nil {
+		return nil, fsys.secureErr
+	}
+	offset, ok := fsys.secureIndex[id]
+	if !ok {
+		return nil, fmt.Errorf("security id %d not found", id)
+	}
+
+	header := make([]byte, sdsHeaderSize)
+	if _, err := fsys.sds.ReadAt(header, offset); err != nil {
+		return nil, err
+	}
+	length := int64(binary.LittleEndian.Uint32(header[16:]))
+	if binary.LittleEndian.Uint32(header[4:]) != id || length < sdsHeaderSize ||
+		length-sdsHeaderSize > maxSecurityDescriptorSize || length > fsys.sds.size-offset {
+		return nil, fmt.Errorf("invalid $SDS entry for security id %d", id)
+	}
+	data := make([]byte, length-sdsHeaderSize)
+	if _, err := fsys.sds.ReadAt(data, offset+sdsHeaderSize); err != nil && err != io.EOF {
+		return nil, err
+	}
+	descriptor, err := parseSecurityDescriptor(data)
+	if err != nil {
+		return nil, err
+	}
+	descriptor.ID = id
+	return descriptor, nil
+}
+
+// readSecure reads the $SII index, which maps security ids to offsets in the
+// $SDS stream.
+func (fsys *FS) readSecure() (map[uint32]int64, *streamReader, error) {
+	entry, err := fsys.ntfsCtx.GetMFT(secureEntry)
+	if err != nil {
+		return nil, nil, err
+	}
+	attribute, err := dataAttribute(fsys.ntfsCtx, entry, "$SDS")
+	if err != nil {
+		return nil, nil, err
+	}
+	sds, err := newStreamReader(fsys.ntfsCtx, entry, attribute)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	index := map[uint32]int64{}
+	add := func(key, data []byte) {
+		if len(key) >= 4 && len(data) >= sdsHeaderSize {
+			index[binary.LittleEndian.Uint32(key)] = int64(binary.LittleEndian.Uint64(data[8:]))
+		}
+	}
+
+	root := namedAttribute(fsys.ntfsCtx, entry, indexRootAttributeType, "$SII")
+	if root == nil {
+		return nil, nil, errors.New("$SII index not found")
+	}
+	rootData := make([]byte, root.DataSize())
+	if _, err := root.Data(fsys.ntfsCtx).ReadAt(rootData, 0); err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	if len(rootData) < 32 {
+		return nil, nil, errors.New("invalid $SII index root")
+	}
+	indexRecordSize := int64(binary.LittleEndian.Uint32(rootData[8:]))
+	walkIndexNode(rootData[16:], add)
+
+	allocation := namedAttribute(fsys.ntfsCtx, entry, indexAllocationAttributeType, "$SII")
+	if allocation == nil {
+		return index, sds, nil
+	}
+	if !validIndexRecordSize(indexRecordSize) {
+		return nil, nil, errors.New("invalid $SII index record size")
+	}
+	r, err := newStreamReader(fsys.ntfsCtx, entry, allocation)
+	if err != nil {
+		return nil, nil, err
+	}
+	// all index records contain entries, so the tree is not traversed
+	record := make([]byte, indexRecordSize)
+	for offset := int64(0); offset+indexRecordSize <= r.size; offset += indexRecordSize {
+		if _, err := r.ReadAt(record, offset); err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		if string(record[:4]) != "INDX" {
+			continue
+		}
+		if err := ntfsutil.Fixup(record); err != nil {
+			continue
+		}
+		walkIndexNode(record[24:], add)
+	}
+	return index, sds, nil
+}
+
+// walkIndexNode calls fn for the key and the data of every entry of an index
+// node.
+func walkIndexNode(node []byte, fn func(key, data []byte)) {
+	if len(node) < 16 {
+		return
+	}
+	offset := int(binary.LittleEndian.Uint32(node))
+	end := int(binary.LittleEndian.Uint32(node[4:]))
+	if end > len(node) {
+		end = len(node)
+	}
+	for offset+16 <= end {
+		entry := node[offset:]
+		dataOffset := int(binary.LittleEndian.Uint16(entry))
+		dataLength := int(binary.LittleEndian.Uint16(entry[2:]))
+		length := int(binary.LittleEndian.Uint16(entry[8:]))
+		keyLength := int(binary.LittleEndian.Uint16(entry[10:]))
+		flags := binary.LittleEndian.Uint16(entry[12:])
+		if flags&0x2 != 0 || length < 16 || offset+length > end {
+			return // last entry
+		}
+		if 16+keyLength <= length && dataOffset+dataLength <= length {
+			fn(entry[16:16+keyLength], entry[dataOffset:dataOffset+dataLength])
+		}
+		offset += length
+	}
+}
+
+// namedAttribute returns the first attribute with the given type and name.
+func namedAttribute(ntfsCtx *parser.NTFSContext, entry *parser.MFT_ENTRY, attributeType uint64, name string) *parser.NTFS_ATTRIBUTE {
+	for _, attribute := range entry.EnumerateAttributes(ntfsCtx) {
+		if attribute.Type().Value == attributeType && attribute.Name() == name && isFirstVCN(attribute) {
+			return attribute
+		}
+	}
+	return nil
+}
+
+// parseSecurityDescriptor parses a self-relative security descriptor.
+func parseSecurityDescriptor(data []byte) (*SecurityDescriptor, error) {
+	if len(data) < 20 {
+		return nil, errors.New("security descriptor too short")
+	}
+	descriptor := &SecurityDescriptor{}
+	if offset := binary.LittleEndian.Uint32(data[4:]); offset != 0 {
+		descriptor.Owner, _ = parseSID(data, int(offset))
+	}
+	if offset := binary.LittleEndian.Uint32(data[8:]); offset != 0 {
+		descriptor.Group, _ = parseSID(data, int(offset))
+	}
+	if offset := int(binary.LittleEndian.Uint32(data[16:])); offset != 0 && offset+8 <= len(data) {
+		acl := data[offset:]
+		count := int(binary.LittleEndian.Uint16(acl[4:]))
+		pos := 8
+		for i := 0; i < count && pos+8 <= len(acl); i++ {
+			size := int(binary.LittleEndian.Uint16(acl[pos+2:]))
+			if size < 8 || pos+size > len(acl) {
+				break
+			}
+			ace := ACE{Type: acl[pos], Flags: acl[pos+1], Mask: binary.LittleEndian.Uint32(acl[pos+4:])}
+			switch ace.Type {
+			case ACEAccessAllowed, ACEAccessDenied, ACESystemAudit, ACESystemAlarm, ACEMandatoryLabel:
+				ace.SID, _ = parseSID(acl[:pos+size], pos+8)
+			}
+			descriptor.DACL = append(descriptor.DACL, ace)
+			pos += size
+		}
+	}
+	return descriptor, nil
+}
+
+// parseSID parses a binary SID at offset.
+func parseSID(data []byte, offset int) (SID, error) {
+	if offset+8 > len(data) {
+		return "", errors.New("SID out of bounds")
+	}
+	sid := data[offset:]
+	count := int(sid[1])
+	if 8+count*4 > len(sid) {
+		return "", errors.New("SID out of bounds")
+	}
+	var authority uint64
+	for _, b := range sid[2:8] {
+		authority = authority<<8 | uint64(b)
+	}
+	s := fmt.Sprintf("S-%d-%d", sid[0], authority)
+	for i := 0; i < count; i++ {
+		s += fmt.Sprintf("-%d", binary.LittleEndian.Uint32(sid[8+i*4:]))
+	}
+	return SID(s), nil
+}
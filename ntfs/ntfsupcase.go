@@ -0,0 +1,235 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package ntfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"unicode/utf16"
+
+	"github.com/forensicanalysis/fslib/ntfs/internal/ntfsutil"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+const (
+	upcaseEntry = 10
+	// upcaseSize is the size of $UpCase, which has an entry for each of the
+	// 65536 UTF-16 code units.
+	upcaseSize = 0x10000 * 2
+
+	indexEntryHasSubnode = 0x01
+	indexEntryLast       = 0x02
+
+	// maxIndexDepth limits the descent into corrupted index B-trees.
+	maxIndexDepth = 32
+	// maxIndexRecordSize limits the allocation for index records of
+	// corrupted index roots. Windows uses 4096 byte records.
+	maxIndexRecordSize = 64 * 1024
+)
+
+// upcaseTable returns the content of $UpCase, which maps every UTF-16 code
+// unit to its upper case variant.
+func (fsys *FS) upcaseTable() ([]uint16, error) {
+	fsys.upcaseOnce.Do(func() {
+		entry, err := fsys.ntfsCtx.GetMFT(upcaseEntry)
+		if err != nil {
+			fsys.upcaseErr = err
+			return
+		}
+		attribute, err := dataAttribute(fsys.ntfsCtx, entry, "")
+		if err != nil {
+			fsys.upcaseErr = err
+			return
+		}
+		if attribute.DataSize() != upcaseSize {
+			fsys.upcaseErr = errors.New("invalid $UpCase size")
+			return
+		}
+		data := make([]byte, upcaseSize)
+		n, err := attribute.Data(fsys.ntfsCtx).ReadAt(data, 0)
+		if err != nil && err != io.EOF {
+			fsys.upcaseErr = err
+			return
+		}
+		table := make([]uint16, n/2)
+		for i := range table {
+			table[i] = binary.LittleEndian.Uint16(data[i*2:])
+		}
+		fsys.upcase = table
+	})
+	return fsys.upcase, fsys.upcaseErr
+}
+
+// upcaseName converts a name to upper case UTF-16 with the $UpCase table.
+func upcaseName(table []uint16, chars []uint16) []uint16 {
+	upper := make([]uint16, len(chars))
+	for i, c := range chars {
+		upper[i] = c
+		if int(c) < len(table) {
+			upper[i] = table[c]
+		}
+	}
+	return upper
+}
+
+// collateFileNames compares two upper case names like the file name
+// collation of NTFS indexes, which compares UTF-16 code units.
+func collateFileNames(a, b []uint16) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+// indexLookup searches the $I30 B-tree of a directory for an upper case name.
+// It returns the referenced MFT entry number and sequence number and the name
+// as stored in the index.
+func (fsys *FS) indexLookup(dir *parser.MFT_ENTRY, key, upcase []uint16) (uint64, uint16, string, error) {
+	root := namedAttribute(fsys.ntfsCtx, dir, indexRootAttributeType, indexName)
+	if root == nil {
+		return 0, 0, "", fs.ErrNotExist
+	}
+	data := make([]byte, root.DataSize())
+	n, err := root.Data(fsys.ntfsCtx).ReadAt(data, 0)
+	if err != nil && err != io.EOF {
+		return 0, 0, "", err
+	}
+	if n < 32 {
+		return 0, 0, "", errors.New("index root too short")
+	}
+	recordSize := int64(binary.LittleEndian.Uint32(data[8:]))
+	if !validIndexRecordSize(recordSize) {
+		return 0, 0, "", errors.New("invalid index record size")
+	}
+	vcnSize := fsys.ntfsCtx.ClusterSize
+	if recordSize < vcnSize {
+		vcnSize = 512
+	}
+
+	var allocation *streamReader
+	node := data[16:n]
+	for depth := 0; depth < maxIndexDepth; depth++ {
+		reference, sequence, name, subnode, err := searchIndexNode(node, key, upcase)
+		if err != nil || name != "" {
+			return reference, sequence, name, err
+		}
+		if subnode < 0 {
+			return 0, 0, "", fs.ErrNotExist
+		}
+
+		if allocation == nil {
+			attribute := namedAttribute(fsys.ntfsCtx, dir, indexAllocationAttributeType, indexName)
+			if attribute == nil {
+				return 0, 0, "", errors.New("index allocation not found")
+			}
+			if allocation, err = newStreamReader(fsys.ntfsCtx, dir, attribute); err != nil {
+				return 0, 0, "", err
+			}
+		}
+		record := make([]byte, recordSize)
+		if _, err := allocation.ReadAt(record, subnode*vcnSize); err != nil && err != io.EOF {
+			return 0, 0, "", err
+		}
+		if string(record[:4]) != "INDX" {
+			return 0, 0, "", errors.New("invalid index record")
+		}
+		if err := ntfsutil.Fixup(record); err != nil {
+			return 0, 0, "", err
+		}
+		node = record[24:]
+	}
+	return 0, 0, "", errors.New("index too deep")
+}
+
+// validIndexRecordSize returns if the index record size of an index root is a
+// power of two between 512 bytes and maxIndexRecordSize.
+func validIndexRecordSize(size int64) bool {
+	return size >= 512 && size <= maxIndexRecordSize && size&(size-1) == 0
+}
+
+// searchIndexNode searches the entries of an index node for an upper case
+// name. If the name is not in the node, it returns the VCN of the subnode
+// that may contain the name or -1.
+func searchIndexNode(node []byte, key, upcase []uint16) (reference uint64, sequence uint16, name string, subnode int64, err error) {
+	if len(node) < 16 {
+		return 0, 0, "", -1, errors.New("index node too short")
+	}
+	offset := int(binary.LittleEndian.Uint32(node))
+	end := int(binary.LittleEndian.Uint32(node[4:]))
+	if end > len(node) {
+		return 0, 0, "", -1, errors.New("index node out of bounds")
+	}
+
+	for offset+16 <= end {
+		entry := node[offset:]
+		length := int(binary.LittleEndian.Uint16(entry[8:]))
+		flags := binary.LittleEndian.Uint32(entry[12:])
+		if length < 16 || offset+length > end {
+			return 0, 0, "", -1, errors.New("invalid index entry")
+		}
+
+		descend := flags&indexEntryLast != 0
+		if !descend {
+			if length < 16+fileNameSize {
+				return 0, 0, "", -1, errors.New("invalid index entry")
+			}
+			nameLength := int(entry[16+64])
+			if 16+fileNameSize+nameLength*2 > length {
+				return 0, 0, "", -1, errors.New("index entry name out of bounds")
+			}
+			chars := make([]uint16, nameLength)
+			for i := range chars {
+				chars[i] = binary.LittleEndian.Uint16(entry[16+fileNameSize+i*2:])
+			}
+			switch collateFileNames(key, upcaseName(upcase, chars)) {
+			case 0:
+				reference, sequence := fileReference(entry)
+				return reference, sequence, string(utf16.Decode(chars)), -1, nil
+			case -1:
+				descend = true
+			}
+		}
+
+		if descend {
+			if flags&indexEntryHasSubnode == 0 {
+				return 0, 0, "", -1, nil
+			}
+			return 0, 0, "", int64(binary.LittleEndian.Uint64(entry[length-8:])), nil
+		}
+		offset += length
+	}
+	return 0, 0, "", -1, errors.New("index node without last entry")
+}
@@ -0,0 +1,334 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package ntfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+	"unicode/utf16"
+
+	"github.com/forensicanalysis/fslib/ntfs/internal/ntfsutil"
+)
+
+// Reason flags of USN records.
+const (
+	USNReasonDataOverwrite        = 0x00000001
+	USNReasonDataExtend           = 0x00000002
+	USNReasonDataTruncation       = 0x00000004
+	USNReasonNamedDataOverwrite   = 0x00000010
+	USNReasonNamedDataExtend      = 0x00000020
+	USNReasonNamedDataTruncation  = 0x00000040
+	USNReasonFileCreate           = 0x00000100
+	USNReasonFileDelete           = 0x00000200
+	USNReasonEAChange             = 0x00000400
+	USNReasonSecurityChange       = 0x00000800
+	USNReasonRenameOldName        = 0x00001000
+	USNReasonRenameNewName        = 0x00002000
+	USNReasonIndexableChange      = 0x00004000
+	USNReasonBasicInfoChange      = 0x00008000
+	USNReasonHardLinkChange       = 0x00010000
+	USNReasonCompressionChange    = 0x00020000
+	USNReasonEncryptionChange     = 0x00040000
+	USNReasonObjectIDChange       = 0x00080000
+	USNReasonReparsePointChange   = 0x00100000
+	USNReasonStreamChange         = 0x00200000
+	USNReasonTransactedChange     = 0x00400000
+	USNReasonIntegrityChange      = 0x00800000
+	USNReasonDesiredStorageChange = 0x01000000
+	USNReasonClose                = 0x80000000
+)
+
+const (
+	usnJournalPath   = "/$Extend/$UsnJrnl"
+	usnJournalStream = "$J"
+
+	usnBufferSize    = 64 * 1024
+	usnMaxRecordSize = 64 * 1024
+)
+
+// USNRecord is an entry of the USN change journal. Version 4 records
+// describe the changed ranges of a file and have neither a timestamp nor a
+// name.
+type USNRecord struct {
+	MajorVersion uint16
+	MinorVersion uint16
+	USN          int64
+
+	Entry          uint64
+	Sequence       uint16
+	ParentEntry    uint64
+	ParentSequence uint16
+
+	Timestamp      time.Time
+	Reason         uint32
+	SourceInfo     uint32
+	SecurityID     uint32
+	FileAttributes uint32
+	Name           string
+
+	// Extents contains the changed ranges of version 4 records.
+	Extents []USNExtent
+}
+
+// USNExtent is a changed range of a file.
+type USNExtent struct {
+	Offset int64
+	Length int64
+}
+
+// USNJournal iterates the records of the $UsnJrnl:$J stream. The stream is
+// sparse, only the allocated ranges are read. A USNJournal must not be used
+// by multiple goroutines.
+type USNJournal struct {
+	fsys   *FS
+	r      io.ReaderAt
+	ranges []Extent
+
+	index  int
+	offset int64
+
+	buf       []byte
+	bufOffset int64
+
+	parents map[uint64]string
+}
+
+// USNJournal opens the USN change journal.
+func (fsys *FS) USNJournal() (*USNJournal, error) {
+	root, err := fsys.ntfsCtx.GetMFT(rootEntry)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := root.Open(fsys.ntfsCtx, usnJournalPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", usnJournalPath, fs.ErrNotExist)
+	}
+	attribute, err := dataAttribute(fsys.ntfsCtx, entry, usnJournalStream)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s: %w", usnJournalPath, usnJournalStream, fs.ErrNotExist)
+	}
+	r, err := newStreamReader(fsys.ntfsCtx, entry, attribute)
+	if err != nil {
+		return nil, err
+	}
+	return newUSNJournal(fsys, r, allocatedExtents(r.extents)), nil
+}
+
+func newUSNJournal(fsys *FS, r io.ReaderAt, ranges []Extent) *USNJournal {
+	return &USNJournal{fsys: fsys, r: r, ranges: ranges, parents: map[uint64]string{}}
+}
+
+// allocatedExtents returns the extents that are not sparse.
+func allocatedExtents(extents []Extent) []Extent {
+	var allocated []Extent
+	for _, extent := range extents {
+		if !extent.Sparse {
+			allocated = append(allocated, extent)
+		}
+	}
+	return allocated
+}
+
+// Next returns the next record of the journal. It returns io.EOF if there
+// are no more records. Invalid records are skipped.
+func (j *USNJournal) Next() (*USNRecord, error) {
+	for ; j.index < len(j.ranges); j.index++ {
+		rng := j.ranges[j.index]
+		end := rng.Offset + rng.Length
+		if j.offset < rng.Offset {
+			j.offset = rng.Offset
+		}
+
+		for j.offset+8 <= end {
+			header, err := j.read(j.offset, 8, end)
+			if err != nil {
+				return nil, err
+			}
+			length := int64(binary.LittleEndian.Uint32(header))
+			if length < 8 || length > usnMaxRecordSize || j.offset+length > end {
+				// padding or damaged record
+				j.offset += 8
+				continue
+			}
+
+			data, err := j.read(j.offset, length, end)
+			if err != nil {
+				return nil, err
+			}
+			record, err := parseUSNRecord(data)
+			if err != nil {
+				j.offset += 8
+				continue
+			}
+			j.offset += (length + 7) &^ 7
+			return record, nil
+		}
+	}
+	return nil, io.EOF
+}
+
+// read returns length bytes at off. Reads are buffered, but do not exceed
+// end.
+func (j *USNJournal) read(off, length, end int64) ([]byte, error) {
+	if off >= j.bufOffset && off+length <= j.bufOffset+int64(len(j.buf)) {
+		return j.buf[off-j.bufOffset : off-j.bufOffset+length], nil
+	}
+
+	size := int64(usnBufferSize)
+	if size < length {
+		size = length
+	}
+	if off+size > end {
+		size = end - off
+	}
+	buf := make([]byte, size)
+	n, err := j.r.ReadAt(buf, off)
+	if int64(n) < length {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	j.buf, j.bufOffset = buf[:n], off
+	return j.buf[:length], nil
+}
+
+// Path returns the path of the file of a record. It is resolved through the
+// parent reference, so it reflects the current location of the parent
+// directory. Paths of files whose parent directory does not exist anymore
+// start with $OrphanFiles.
+func (j *USNJournal) Path(record *USNRecord) string {
+	if record.Name == "" {
+		entry, err := j.fsys.ntfsCtx.GetMFT(int64(record.Entry))
+		if err != nil || entry.Sequence_value() != record.Sequence {
+			return path.Join(orphanDir, fmt.Sprint(record.Entry))
+		}
+		return j.fsys.fullPath(entry)
+	}
+	return path.Join(j.parentPath(record.ParentEntry, record.ParentSequence), record.Name)
+}
+
+func (j *USNJournal) parentPath(entry uint64, sequence uint16) string {
+	reference := entry | uint64(sequence)<<48
+	if name, ok := j.parents[reference]; ok {
+		return name
+	}
+	name := orphanDir
+	if parent, err := j.fsys.ntfsCtx.GetMFT(int64(entry)); err == nil && isParent(parent, sequence) {
+		name = j.fsys.fullPath(parent)
+	}
+	j.parents[reference] = name
+	return name
+}
+
+// parseUSNRecord parses a version 2, 3 or 4 USN record.
+func parseUSNRecord(data []byte) (*USNRecord, error) {
+	record := &USNRecord{
+		MajorVersion: binary.LittleEndian.Uint16(data[4:]),
+		MinorVersion: binary.LittleEndian.Uint16(data[6:]),
+	}
+
+	var fieldsOffset int
+	switch record.MajorVersion {
+	case 2:
+		if len(data) < 60 {
+			return nil, errors.New("USN record too short")
+		}
+		record.Entry, record.Sequence = fileReference(data[8:])
+		record.ParentEntry, record.ParentSequence = fileReference(data[16:])
+		record.USN = int64(binary.LittleEndian.Uint64(data[24:]))
+		fieldsOffset = 32
+	case 3, 4:
+		if len(data) < 64 {
+			return nil, errors.New("USN record too short")
+		}
+		// 128 bit file ids contain the 64 bit file reference on NTFS
+		record.Entry, record.Sequence = fileReference(data[8:])
+		record.ParentEntry, record.ParentSequence = fileReference(data[24:])
+		record.USN = int64(binary.LittleEndian.Uint64(data[40:]))
+		fieldsOffset = 48
+	default:
+		return nil, fmt.Errorf("unsupported USN record version %d", record.MajorVersion)
+	}
+
+	if record.MajorVersion == 4 {
+		return record, parseUSNExtents(record, data)
+	}
+
+	if len(data) < fieldsOffset+28 {
+		return nil, errors.New("USN record too short")
+	}
+	fields := data[fieldsOffset:]
+	record.Timestamp = ntfsutil.Filetime(binary.LittleEndian.Uint64(fields))
+	record.Reason = binary.LittleEndian.Uint32(fields[8:])
+	record.SourceInfo = binary.LittleEndian.Uint32(fields[12:])
+	record.SecurityID = binary.LittleEndian.Uint32(fields[16:])
+	record.FileAttributes = binary.LittleEndian.Uint32(fields[20:])
+	nameLength := int(binary.LittleEndian.Uint16(fields[24:]))
+	nameStart := int(binary.LittleEndian.Uint16(fields[26:]))
+	if nameStart+nameLength > len(data) {
+		return nil, errors.New("USN record name out of bounds")
+	}
+	record.Name = decodeUTF16(data[nameStart : nameStart+nameLength])
+	return record, nil
+}
+
+// parseUSNExtents parses the reason and the changed ranges of a version 4
+// record.
+func parseUSNExtents(record *USNRecord, data []byte) error {
+	record.Reason = binary.LittleEndian.Uint32(data[48:])
+	record.SourceInfo = binary.LittleEndian.Uint32(data[52:])
+	count := int(binary.LittleEndian.Uint16(data[60:]))
+	size := int(binary.LittleEndian.Uint16(data[62:]))
+	if size < 16 || 64+count*size > len(data) {
+		return errors.New("USN record extents out of bounds")
+	}
+	for i := 0; i < count; i++ {
+		extent := data[64+i*size:]
+		record.Extents = append(record.Extents, USNExtent{
+			Offset: int64(binary.LittleEndian.Uint64(extent)),
+			Length: int64(binary.LittleEndian.Uint64(extent[8:])),
+		})
+	}
+	return nil
+}
+
+// fileReference splits a file reference into the MFT entry number and the
+// sequence number.
+func fileReference(data []byte) (uint64, uint16) {
+	reference := binary.LittleEndian.Uint64(data)
+	return reference & 0xffffffffffff, uint16(reference >> 48)
+}
+
+func decodeUTF16(data []byte) string {
+	chars := make([]uint16, len(data)/2)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+	return string(utf16.Decode(chars))
+}
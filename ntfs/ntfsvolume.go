@@ -0,0 +1,184 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package ntfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+const (
+	volumeEntry = 3
+
+	volumeNameAttributeType        = 0x60
+	volumeInformationAttributeType = 0x70
+	// maxVolumeAttributeSize limits the size of $VOLUME_NAME and
+	// $VOLUME_INFORMATION, which are resident and therefore smaller than an
+	// MFT record.
+	maxVolumeAttributeSize = 4096
+
+	// VolumeDirty is set in the volume flags if the volume was not
+	// unmounted cleanly and must be checked.
+	VolumeDirty = 0x0001
+)
+
+// VolumeInfo contains the metadata of an NTFS volume from the boot sector,
+// $Volume and $Bitmap.
+type VolumeInfo struct {
+	Label        string
+	SerialNumber uint64
+
+	// MajorVersion and MinorVersion are the NTFS version, e.g. 3.1.
+	MajorVersion uint8
+	MinorVersion uint8
+	// Flags are the volume flags of $Volume, see VolumeDirty.
+	Flags uint16
+	Dirty bool
+
+	BytesPerSector  int64
+	ClusterSize     int64
+	RecordSize      int64
+	IndexRecordSize int64
+
+	// MFTCluster and MFTMirrCluster are the first clusters of $MFT and
+	// $MFTMirr.
+	MFTCluster     int64
+	MFTMirrCluster int64
+
+	TotalClusters int64
+	// FreeClusters is the number of clusters that are not allocated in
+	// $Bitmap.
+	FreeClusters int64
+}
+
+// VolumeInfo returns the metadata of the volume.
+func (fsys *FS) VolumeInfo() (*VolumeInfo, error) {
+	boot := make([]byte, 512)
+	if _, err := fsys.ntfsCtx.DiskReader.ReadAt(boot, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	info, err := parseBootSector(boot)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := fsys.ntfsCtx.GetMFT(volumeEntry)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := attributeData(fsys.ntfsCtx, entry, volumeNameAttributeType, maxVolumeAttributeSize); err == nil {
+		info.Label = decodeUTF16(data)
+	}
+	data, err := attributeData(fsys.ntfsCtx, entry, volumeInformationAttributeType, maxVolumeAttributeSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 {
+		return nil, errors.New("volume information too short")
+	}
+	info.MajorVersion = data[8]
+	info.MinorVersion = data[9]
+	info.Flags = binary.LittleEndian.Uint16(data[10:])
+	info.Dirty = info.Flags&VolumeDirty != 0
+
+	bitmap, err := fsys.clusterBitmap()
+	if err != nil {
+		return nil, err
+	}
+	info.FreeClusters = info.TotalClusters - allocatedClusters(bitmap, info.TotalClusters)
+	return info, nil
+}
+
+// parseBootSector parses the sizes and locations of the NTFS boot sector.
+func parseBootSector(boot []byte) (*VolumeInfo, error) {
+	if string(boot[3:11]) != "NTFS    " {
+		return nil, errors.New("no NTFS boot sector")
+	}
+	info := &VolumeInfo{
+		BytesPerSector: int64(binary.LittleEndian.Uint16(boot[0x0B:])),
+		MFTCluster:     int64(binary.LittleEndian.Uint64(boot[0x30:])),
+		MFTMirrCluster: int64(binary.LittleEndian.Uint64(boot[0x38:])),
+		SerialNumber:   binary.LittleEndian.Uint64(boot[0x48:]),
+	}
+
+	// sector counts above 0x80 are negative powers of two
+	sectorsPerCluster := int64(boot[0x0D])
+	if sectorsPerCluster > 0x80 {
+		sectorsPerCluster = 1 << uint(256-sectorsPerCluster)
+	}
+	info.ClusterSize = info.BytesPerSector * sectorsPerCluster
+	if info.ClusterSize == 0 {
+		return nil, errors.New("invalid cluster size")
+	}
+	info.TotalClusters = int64(binary.LittleEndian.Uint64(boot[0x28:])) / sectorsPerCluster
+	info.RecordSize = recordSize(int8(boot[0x40]), info.ClusterSize)
+	info.IndexRecordSize = recordSize(int8(boot[0x44]), info.ClusterSize)
+	return info, nil
+}
+
+// recordSize decodes the size of MFT and index records, which is given in
+// clusters or as negative power of two in bytes.
+func recordSize(size int8, clusterSize int64) int64 {
+	if size < 0 {
+		return 1 << uint(-size)
+	}
+	return int64(size) * clusterSize
+}
+
+// allocatedClusters counts the bits of the first clusters bits of bitmap.
+func allocatedClusters(bitmap []byte, clusters int64) int64 {
+	var count int64
+	for i, b := range bitmap {
+		if int64(i)*8 >= clusters {
+			break
+		}
+		if rest := clusters - int64(i)*8; rest < 8 {
+			b &= byte(1<<uint(rest)) - 1
+		}
+		count += int64(bits.OnesCount8(b))
+	}
+	return count
+}
+
+// attributeData returns the content of the first unnamed attribute of the
+// given type. Attributes larger than maxSize are rejected.
+func attributeData(ntfsCtx *parser.NTFSContext, entry *parser.MFT_ENTRY, attributeType uint64, maxSize int64) ([]byte, error) {
+	attribute := namedAttribute(ntfsCtx, entry, attributeType, "")
+	if attribute == nil {
+		return nil, errors.New("attribute not found")
+	}
+	size := attribute.DataSize()
+	if size < 0 || size > maxSize {
+		return nil, errors.New("invalid attribute size")
+	}
+	data := make([]byte, size)
+	n, err := attribute.Data(ntfsCtx).ReadAt(data, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data[:n], nil
+}
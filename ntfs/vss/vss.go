@@ -0,0 +1,322 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+// Package vss provides an io/fs implementation of the Volume Shadow Copies
+// (VSS) of an NTFS volume. The snapshots are listed as vss0, vss1, ... ordered
+// by their creation time. Every snapshot can be read as raw volume or opened
+// as ntfs.FS.
+package vss
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/forensicanalysis/fslib/ntfs/internal/ntfsutil"
+)
+
+const (
+	headerOffset = 0x1e00
+	blockSize    = 0x4000
+	sectorSize   = 512
+
+	blockHeaderSize     = 128
+	catalogEntrySize    = 128
+	blockDescriptorSize = 32
+
+	recordTypeVolumeHeader = 1
+	recordTypeCatalog      = 2
+	recordTypeStoreBlocks  = 4
+
+	catalogEntrySnapshot = 2
+	catalogEntryStore    = 3
+
+	descriptorForwarder = 0x1
+	descriptorOverlay   = 0x2
+	descriptorNotUsed   = 0x4
+)
+
+// vssIdentifier is the GUID 3808876b-c176-4e48-b7ae-04046e6cc752.
+var vssIdentifier = []byte{0x6b, 0x87, 0x08, 0x38, 0x76, 0xc1, 0x48, 0x4e, 0xb7, 0xae, 0x04, 0x04, 0x6e, 0x6c, 0xc7, 0x52}
+
+type volumeHeader struct {
+	Identifier    [16]byte
+	Version       uint32
+	RecordType    uint32
+	CurrentOffset uint64
+	_             [16]byte
+	CatalogOffset uint64
+	MaximumSize   uint64
+	VolumeID      [16]byte
+	StoreVolumeID [16]byte
+}
+
+type blockHeader struct {
+	Identifier     [16]byte
+	Version        uint32
+	RecordType     uint32
+	RelativeOffset uint64
+	CurrentOffset  uint64
+	NextOffset     uint64
+}
+
+type snapshotEntry struct {
+	EntryType  uint64
+	VolumeSize uint64
+	StoreID    [16]byte
+	_          [16]byte
+	Created    uint64
+}
+
+type storeEntry struct {
+	EntryType            uint64
+	BlockListOffset      uint64
+	StoreID              [16]byte
+	HeaderOffset         uint64
+	BlockRangeListOffset uint64
+	BitmapOffset         uint64
+}
+
+type blockDescriptor struct {
+	OriginalOffset uint64
+	RelativeOffset uint64
+	StoreOffset    uint64
+	Flags          uint32
+	Bitmap         uint32
+}
+
+// SnapshotInfo describes a shadow copy. It is returned by the Sys method of
+// snapshots.
+type SnapshotInfo struct {
+	Index      int
+	StoreID    [16]byte
+	Created    time.Time
+	VolumeSize int64
+}
+
+// store contains the blocks that were copied before they were overwritten
+// after a snapshot was created.
+type store struct {
+	info     *SnapshotInfo
+	blocks   map[int64]*blockDescriptor
+	overlays map[int64]*blockDescriptor
+}
+
+// FS implements a read-only file system for the shadow copies of a volume.
+type FS struct {
+	volume io.ReaderAt
+	stores []*store
+}
+
+// New parses the VSS catalog and the store block lists of a volume.
+func New(volume io.ReaderAt) (*FS, error) {
+	header := &volumeHeader{}
+	if err := binary.Read(io.NewSectionReader(volume, headerOffset, int64(binary.Size(header))), binary.LittleEndian, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header.Identifier[:], vssIdentifier) || header.RecordType != recordTypeVolumeHeader {
+		return nil, errors.New("no volume shadow copy header")
+	}
+
+	fsys := &FS{volume: volume}
+	if header.CatalogOffset == 0 {
+		return fsys, nil
+	}
+
+	entries, err := readCatalog(volume, int64(header.CatalogOffset))
+	if err != nil {
+		return nil, err
+	}
+
+	// snapshot and store entries are linked by the store id
+	snapshots := map[[16]byte]*snapshotEntry{}
+	for _, entry := range entries {
+		if snapshot, ok := entry.(*snapshotEntry); ok {
+			snapshots[snapshot.StoreID] = snapshot
+		}
+	}
+	for _, entry := range entries {
+		storeEntry, ok := entry.(*storeEntry)
+		if !ok {
+			continue
+		}
+		snapshot, ok := snapshots[storeEntry.StoreID]
+		if !ok {
+			continue
+		}
+		s := &store{
+			info: &SnapshotInfo{
+				StoreID:    storeEntry.StoreID,
+				Created:    ntfsutil.Filetime(snapshot.Created),
+				VolumeSize: int64(snapshot.VolumeSize),
+			},
+			blocks:   map[int64]*blockDescriptor{},
+			overlays: map[int64]*blockDescriptor{},
+		}
+		if err := s.readBlockList(volume, int64(storeEntry.BlockListOffset)); err != nil {
+			return nil, err
+		}
+		fsys.stores = append(fsys.stores, s)
+	}
+
+	sort.Sort(byCreation(fsys.stores))
+	for i, s := range fsys.stores {
+		s.info.Index = i
+	}
+	return fsys, nil
+}
+
+// readBlock reads a 16 KiB block of the VSS metadata and verifies its header.
+func readBlock(volume io.ReaderAt, offset int64, recordType uint32) ([]byte, *blockHeader, error) {
+	block := make([]byte, blockSize)
+	if _, err := volume.ReadAt(block, offset); err != nil {
+		return nil, nil, err
+	}
+	header := &blockHeader{}
+	if err := binary.Read(bytes.NewReader(block), binary.LittleEndian, header); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(header.Identifier[:], vssIdentifier) || header.RecordType != recordType {
+		return nil, nil, fmt.Errorf("invalid VSS block at %d", offset)
+	}
+	return block, header, nil
+}
+
+// readCatalog returns the snapshot and store entries of all catalog blocks.
+func readCatalog(volume io.ReaderAt, offset int64) ([]interface{}, error) {
+	var entries []interface{}
+	seen := map[int64]bool{}
+	for offset != 0 && !seen[offset] {
+		seen[offset] = true
+		block, header, err := readBlock(volume, offset, recordTypeCatalog)
+		if err != nil {
+			return nil, err
+		}
+		for pos := blockHeaderSize; pos+catalogEntrySize <= blockSize; pos += catalogEntrySize {
+			data := bytes.NewReader(block[pos : pos+catalogEntrySize])
+			var entry interface{}
+			switch binary.LittleEndian.Uint64(block[pos:]) {
+			case catalogEntrySnapshot:
+				entry = &snapshotEntry{}
+			case catalogEntryStore:
+				entry = &storeEntry{}
+			default:
+				continue
+			}
+			if err := binary.Read(data, binary.LittleEndian, entry); err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+		offset = int64(header.NextOffset)
+	}
+	return entries, nil
+}
+
+// readBlockList reads the block descriptors of a store.
+func (s *store) readBlockList(volume io.ReaderAt, offset int64) error {
+	seen := map[int64]bool{}
+	for offset != 0 && !seen[offset] {
+		seen[offset] = true
+		block, header, err := readBlock(volume, offset, recordTypeStoreBlocks)
+		if err != nil {
+			return err
+		}
+		for pos := blockHeaderSize; pos+blockDescriptorSize <= blockSize; pos += blockDescriptorSize {
+			descriptor := &blockDescriptor{}
+			if err := binary.Read(bytes.NewReader(block[pos:pos+blockDescriptorSize]), binary.LittleEndian, descriptor); err != nil {
+				return err
+			}
+			if descriptor.OriginalOffset == 0 && descriptor.RelativeOffset == 0 && descriptor.StoreOffset == 0 {
+				continue
+			}
+			if descriptor.Flags&descriptorNotUsed != 0 {
+				continue
+			}
+			original := int64(descriptor.OriginalOffset)
+			if descriptor.Flags&descriptorOverlay != 0 {
+				if existing, ok := s.overlays[original]; ok {
+					// later overlays add sectors to the block
+					existing.Bitmap |= descriptor.Bitmap
+					continue
+				}
+				s.overlays[original] = descriptor
+				continue
+			}
+			s.blocks[original] = descriptor
+		}
+		offset = int64(header.NextOffset)
+	}
+	return nil
+}
+
+// Open returns the pseudo root "." or a snapshot named vss0, vss1, ...
+func (fsys *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &Root{fsys: fsys}, nil
+	}
+	if !strings.HasPrefix(name, "vss") {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	// only canonical names, so "vss01" is not an alias of "vss1"
+	index, err := strconv.Atoi(name[3:])
+	if err != nil || index < 0 || index >= len(fsys.stores) || name != "vss"+strconv.Itoa(index) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return fsys.snapshot(index), nil
+}
+
+// Snapshots returns the number of shadow copies.
+func (fsys *FS) Snapshots() int { return len(fsys.stores) }
+
+// Snapshot returns a shadow copy. Index 0 is the oldest snapshot, indexes
+// must be less than Snapshots().
+func (fsys *FS) Snapshot(index int) (*Snapshot, error) {
+	if index < 0 || index >= len(fsys.stores) {
+		return nil, fmt.Errorf("snapshot %d does not exist, the volume has %d snapshots", index, len(fsys.stores))
+	}
+	return fsys.snapshot(index), nil
+}
+
+func (fsys *FS) snapshot(index int) *Snapshot {
+	r := &snapshotReader{fsys: fsys, index: index}
+	return &Snapshot{
+		SectionReader: io.NewSectionReader(r, 0, fsys.stores[index].info.VolumeSize),
+		info:          fsys.stores[index].info,
+	}
+}
+
+type byCreation []*store
+
+func (s byCreation) Len() int           { return len(s) }
+func (s byCreation) Less(i, j int) bool { return s[i].info.Created.Before(s[j].info.Created) }
+func (s byCreation) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
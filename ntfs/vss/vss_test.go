@@ -0,0 +1,197 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package vss
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+)
+
+type builder struct {
+	data []byte
+}
+
+func (b *builder) block(offset int64, recordType uint32, next int64) []byte {
+	block := b.data[offset : offset+blockSize]
+	copy(block, vssIdentifier)
+	binary.LittleEndian.PutUint32(block[16:], 1)
+	binary.LittleEndian.PutUint32(block[20:], recordType)
+	binary.LittleEndian.PutUint64(block[32:], uint64(offset))
+	binary.LittleEndian.PutUint64(block[40:], uint64(next))
+	return block
+}
+
+func (b *builder) header(catalog int64) {
+	copy(b.data[headerOffset:], vssIdentifier)
+	binary.LittleEndian.PutUint32(b.data[headerOffset+16:], 1)
+	binary.LittleEndian.PutUint32(b.data[headerOffset+20:], recordTypeVolumeHeader)
+	binary.LittleEndian.PutUint64(b.data[headerOffset+48:], uint64(catalog))
+}
+
+// snapshot adds a snapshot and a store entry to a catalog block.
+func (b *builder) snapshot(catalog []byte, slot int, id byte, created time.Time, volumeSize, blockList int64) {
+	entry := catalog[blockHeaderSize+slot*2*catalogEntrySize:]
+	binary.LittleEndian.PutUint64(entry, catalogEntrySnapshot)
+	binary.LittleEndian.PutUint64(entry[8:], uint64(volumeSize))
+	entry[16] = id
+	binary.LittleEndian.PutUint64(entry[48:], uint64(created.Unix()+11644473600)*1e7)
+
+	entry = entry[catalogEntrySize:]
+	binary.LittleEndian.PutUint64(entry, catalogEntryStore)
+	binary.LittleEndian.PutUint64(entry[8:], uint64(blockList))
+	entry[16] = id
+}
+
+func descriptor(blockList []byte, slot int, original, relative, store int64, flags, bitmap uint32) {
+	d := blockList[blockHeaderSize+slot*blockDescriptorSize:]
+	binary.LittleEndian.PutUint64(d, uint64(original))
+	binary.LittleEndian.PutUint64(d[8:], uint64(relative))
+	binary.LittleEndian.PutUint64(d[16:], uint64(store))
+	binary.LittleEndian.PutUint32(d[24:], flags)
+	binary.LittleEndian.PutUint32(d[28:], bitmap)
+}
+
+func TestSnapshots(t *testing.T) {
+	const volumeSize = 16 * blockSize
+	b := &builder{data: make([]byte, volumeSize)}
+	fill := func(offset int64, length int, c byte) {
+		copy(b.data[offset:], bytes.Repeat([]byte{c}, length))
+	}
+	fill(1*blockSize, blockSize, 'C')
+	fill(2*blockSize, blockSize, 'c')
+	fill(3*blockSize, blockSize, 'x')
+	fill(8*blockSize, blockSize, 'B')
+	fill(9*blockSize, blockSize, 'O')
+
+	b.header(4 * blockSize)
+	catalog := b.block(4*blockSize, recordTypeCatalog, 0)
+	older := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)
+	b.snapshot(catalog, 0, 'B', newer, volumeSize, 5*blockSize)
+	b.snapshot(catalog, 1, 'A', older, volumeSize, 6*blockSize)
+
+	// the newer store contains the old content of block 1
+	storeB := b.block(5*blockSize, recordTypeStoreBlocks, 0)
+	descriptor(storeB, 0, 1*blockSize, 0, 8*blockSize, 0, 0)
+
+	// block 2 of the older snapshot contains the content of block 3, the
+	// first sector of block 3 was changed
+	storeA := b.block(6*blockSize, recordTypeStoreBlocks, 0)
+	descriptor(storeA, 0, 2*blockSize, 3*blockSize, 0, descriptorForwarder, 0)
+	descriptor(storeA, 1, 3*blockSize, 0, 9*blockSize, descriptorOverlay, 1)
+
+	fsys, err := New(bytes.NewReader(b.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name() != "vss0" || entries[1].Name() != "vss1" {
+		t.Fatalf("ReadDir() = %v", entries)
+	}
+	info, err := entries[0].Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(older) || info.Size() != volumeSize {
+		t.Errorf("vss0: ModTime() = %s, Size() = %d", info.ModTime(), info.Size())
+	}
+
+	tests := []struct {
+		name   string
+		offset int64
+		want   []byte
+	}{
+		{"vss1", 1 * blockSize, bytes.Repeat([]byte{'B'}, blockSize)},
+		{"vss1", 2 * blockSize, bytes.Repeat([]byte{'c'}, blockSize)},
+		{"vss0", 1 * blockSize, bytes.Repeat([]byte{'B'}, blockSize)},
+		{"vss0", 2 * blockSize, bytes.Repeat([]byte{'x'}, blockSize)},
+		{"vss0", 3 * blockSize, append(bytes.Repeat([]byte{'O'}, sectorSize), bytes.Repeat([]byte{'x'}, blockSize-sectorSize)...)},
+		{"vss0", 3*blockSize + sectorSize - 10, append(bytes.Repeat([]byte{'O'}, 10), 'x', 'x')},
+	}
+	for _, tt := range tests {
+		f, err := fsys.Open(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, len(tt.want))
+		if _, err := f.(io.ReaderAt).ReadAt(got, tt.offset); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: ReadAt(%d) = %q..., want %q...", tt.name, tt.offset, got[:16], tt.want[:16])
+		}
+	}
+
+	for _, name := range []string{"vss2", "vss01", "vss+1", "vss-0", "vss"} {
+		if _, err := fsys.Open(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Open(%s) error = %v, want fs.ErrNotExist", name, err)
+		}
+	}
+	if _, err := fsys.Snapshot(2); err == nil {
+		t.Error("Snapshot(2) should fail")
+	}
+	if _, err := fsys.Snapshot(-1); err == nil {
+		t.Error("Snapshot(-1) should fail")
+	}
+}
+
+func TestNTFS(t *testing.T) {
+	image, err := os.ReadFile("../../testdata/filesystem/ntfs.dd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the VSS metadata is appended to the volume
+	size := int64(len(image))
+	b := &builder{data: append(image, make([]byte, 2*blockSize)...)}
+	b.header(size)
+	catalog := b.block(size, recordTypeCatalog, 0)
+	b.snapshot(catalog, 0, 'A', time.Now(), size, size+blockSize)
+	b.block(size+blockSize, recordTypeStoreBlocks, 0)
+
+	fsys, err := New(bytes.NewReader(b.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vss0, err := fsys.Snapshot(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := vss0.NTFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadFile(snapshot, "README.md"); err != nil {
+		t.Fatal(err)
+	}
+}
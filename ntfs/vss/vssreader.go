@@ -0,0 +1,100 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package vss
+
+import (
+	"errors"
+	"io"
+)
+
+// snapshotReader reconstructs a snapshot from the current volume. Blocks
+// that were overwritten after the snapshot was created are read from the
+// store of the snapshot or the stores of newer snapshots.
+type snapshotReader struct {
+	fsys  *FS
+	index int
+}
+
+// ReadAt reads len(p) bytes of the snapshot starting at off.
+func (r *snapshotReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	size := r.fsys.stores[r.index].info.VolumeSize
+	if off >= size {
+		return 0, io.EOF
+	}
+
+	for n < len(p) && off+int64(n) < size {
+		pos := off + int64(n)
+		source, length := r.resolve(pos)
+		if remaining := int64(len(p) - n); length > remaining {
+			length = remaining
+		}
+		if remaining := size - pos; length > remaining {
+			length = remaining
+		}
+
+		m, err := r.fsys.volume.ReadAt(p[n:n+int(length)], source)
+		n += m
+		if err != nil && !(err == io.EOF && int64(m) == length) {
+			return n, err
+		}
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// resolve returns the offset on the volume that contains the data of the
+// snapshot at off and the number of bytes that can be read from there.
+func (r *snapshotReader) resolve(off int64) (int64, int64) {
+	block := off &^ (blockSize - 1)
+	inBlock := off - block
+	length := blockSize - inBlock
+	sector := uint(inBlock / sectorSize)
+
+	for _, s := range r.fsys.stores[r.index:] {
+		if overlay, ok := s.overlays[block]; ok {
+			// only some sectors of the block are stored in the overlay
+			length = sectorSize - inBlock%sectorSize
+			if overlay.Bitmap&(1<<sector) != 0 {
+				return int64(overlay.StoreOffset) + inBlock, length
+			}
+		}
+		descriptor, ok := s.blocks[block]
+		if !ok {
+			continue
+		}
+		if descriptor.Flags&descriptorForwarder != 0 {
+			// the block contains the data of another block of the
+			// following snapshots
+			block = int64(descriptor.RelativeOffset)
+			continue
+		}
+		return int64(descriptor.StoreOffset) + inBlock, length
+	}
+	return block + inBlock, length
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package vss
+
+import (
+	"io"
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// Root is a pseudo root directory containing the snapshots.
+type Root struct {
+	fsys      *FS
+	dirOffset int
+}
+
+func (r *Root) Read([]byte) (int, error) {
+	return 0, syscall.EPERM
+}
+
+// Name always returns '.' for VSS roots.
+func (r *Root) Name() string { return "." }
+
+// ReadDir lists all snapshots of the volume.
+func (r *Root) ReadDir(n int) ([]fs.DirEntry, error) {
+	var snapshots []fs.DirEntry
+	for index := range r.fsys.stores {
+		snapshots = append(snapshots, r.fsys.snapshot(index))
+	}
+
+	// directory already exhausted
+	if n <= 0 && r.dirOffset >= len(snapshots) {
+		return nil, nil
+	}
+
+	var err error
+	// read till end
+	if n > 0 && r.dirOffset+n > len(snapshots) {
+		err = io.EOF
+		if r.dirOffset > len(snapshots) {
+			return nil, err
+		}
+	}
+
+	if n > 0 && r.dirOffset+n <= len(snapshots) {
+		snapshots = snapshots[r.dirOffset : r.dirOffset+n]
+		r.dirOffset += n
+	} else {
+		snapshots = snapshots[r.dirOffset:]
+		r.dirOffset += len(snapshots)
+	}
+
+	return snapshots, err
+}
+
+// Size returns 0 for VSS pseudo roots.
+func (r *Root) Size() int64 { return 0 }
+
+// Mode returns fs.ModeDir for VSS pseudo roots.
+func (r *Root) Mode() fs.FileMode { return fs.ModeDir }
+
+// ModTime returns the zero time (0001-01-01 00:00) for VSS pseudo roots.
+func (r *Root) ModTime() time.Time { return time.Time{} }
+
+// IsDir returns true for VSS pseudo roots.
+func (r *Root) IsDir() bool { return true }
+
+// Sys returns nil for VSS pseudo roots.
+func (r *Root) Sys() interface{} { return nil }
+
+// Close does not do anything for VSS pseudo roots.
+func (r *Root) Close() error { return nil }
+
+// Stat returns the VSS pseudo roots itself as fs.FileMode.
+func (r *Root) Stat() (fs.FileInfo, error) { return r, nil }
@@ -0,0 +1,70 @@
+// Copyright (c) 2019-2020 Siemens AG
+// Copyright (c) 2019-2021 Jonas Plum
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package vss
+
+import (
+	"io"
+	"io/fs"
+	"strconv"
+	"time"
+
+	"github.com/forensicanalysis/fslib/ntfs"
+)
+
+// Snapshot implements fs.File for a shadow copy. It reads the volume as it
+// was when the snapshot was created.
+type Snapshot struct {
+	*io.SectionReader
+	info *SnapshotInfo
+}
+
+// Name returns the name of a snapshot that consists of 'vssX' where X is the
+// index of the snapshot.
+func (s *Snapshot) Name() string { return "vss" + strconv.Itoa(s.info.Index) }
+
+// IsDir returns false for snapshots.
+func (*Snapshot) IsDir() bool { return false }
+
+// Close does not do anything for snapshots.
+func (s *Snapshot) Close() error { return nil }
+
+// Stat return an fs.FileInfo object that describes a file.
+func (s *Snapshot) Stat() (fs.FileInfo, error) { return s, nil }
+
+// Mode returns 0 for snapshots.
+func (s *Snapshot) Mode() fs.FileMode { return 0 }
+
+// ModTime returns the creation time of the snapshot.
+func (s *Snapshot) ModTime() time.Time { return s.info.Created }
+
+// Sys returns the *SnapshotInfo.
+func (s *Snapshot) Sys() interface{} { return s.info }
+
+func (s *Snapshot) Type() fs.FileMode { return s.Mode() }
+
+func (s *Snapshot) Info() (fs.FileInfo, error) { return s, nil }
+
+// NTFS opens the file system of the snapshot.
+func (s *Snapshot) NTFS() (*ntfs.FS, error) {
+	return ntfs.New(s.SectionReader)
+}